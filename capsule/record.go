@@ -0,0 +1,115 @@
+package capsule
+
+// テーブル config とは別に、名前ごとに独立した JSON データを保存するテーブル records を扱う関数
+// config がひとつの設定を丸ごと読み書きするのに対し、こちらは小さなデータを多数並べて置くのに向いている
+// 名前は "dialog/チャンネルの UUID/ユーザーの UUID" のように / 区切りで分類しておくと RecordNames で探しやすい
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+
+	json "encoding/json"
+)
+
+var (
+	recordsReady bool
+	recordsMu    sync.Mutex
+)
+
+func prepareRecords() error {
+	if Db == nil {
+		return fmt.Errorf("database is not connected")
+	}
+
+	recordsMu.Lock()
+	defer recordsMu.Unlock()
+	if recordsReady {
+		return nil
+	}
+
+	// utf8mb4 では 1 文字 4 バイトなので、主キーの長さ制限（767 バイト）に収まるよう 191 文字までとする
+	if _, err := Db.Exec(`CREATE TABLE IF NOT EXISTS records (name VARCHAR(191) PRIMARY KEY, json JSON);`); err != nil {
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+	recordsReady = true
+	return nil
+}
+
+// 名前をつけてデータを保存する。同じ名前のデータがあれば上書きする
+func SaveRecord[T any](name string, record T) error {
+	if err := prepareRecords(); err != nil {
+		return err
+	}
+
+	recordJson, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %v: %w", record, err)
+	}
+
+	if _, err = Db.Exec(
+		`INSERT INTO records (name, json) VALUES (?, ?) ON DUPLICATE KEY UPDATE json = VALUES(json)`, name, string(recordJson),
+	); err != nil {
+		return fmt.Errorf("failed to update the database: %w", err)
+	}
+	return nil
+}
+
+// 名前をつけて保存したデータを読み出す。その名前のデータがなければ 2 つ目の返り値が false になる
+func LoadRecord[T any](name string) (T, bool, error) {
+	var record T // エラーの場合の返り値
+
+	if err := prepareRecords(); err != nil {
+		return record, false, err
+	}
+
+	recordJson := ""
+	if err := Db.Get(&recordJson, `SELECT json FROM records WHERE name = ?`, name); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return record, false, nil
+		}
+		return record, false, fmt.Errorf("failed to get data from database: %w", err)
+	}
+
+	if err := json.Unmarshal([]byte(recordJson), &record); err != nil {
+		return record, false, fmt.Errorf("failed to unmarshal %s: %w", recordJson, err)
+	}
+	return record, true, nil
+}
+
+// 名前をつけて保存したデータを削除する。その名前のデータがなくてもエラーにはしない
+func DeleteRecord(name string) error {
+	if err := prepareRecords(); err != nil {
+		return err
+	}
+	if _, err := Db.Exec(`DELETE FROM records WHERE name = ?`, name); err != nil {
+		return fmt.Errorf("failed to delete from the database: %w", err)
+	}
+	return nil
+}
+
+// 引数から始まる名前の一覧を得る
+func RecordNames(prefix string) ([]string, error) {
+	if err := prepareRecords(); err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	if err := Db.Select(&names, `SELECT name FROM records WHERE name LIKE CONCAT(?, '%') ORDER BY name`, escapeLike(prefix)); err != nil {
+		return nil, fmt.Errorf("failed to get names from database: %w", err)
+	}
+	return names, nil
+}
+
+// LIKE 句で特別な意味を持つ文字をエスケープする
+func escapeLike(text string) string {
+	escaped := []rune{}
+	for _, r := range text {
+		if (r == '%') || (r == '_') || (r == '\\') {
+			escaped = append(escaped, '\\')
+		}
+		escaped = append(escaped, r)
+	}
+	return string(escaped)
+}
@@ -0,0 +1,74 @@
+package main
+
+// チャンネルの全履歴をファイルに書き出すコマンド
+// go run ./cmd/export -channel gps/times/kitsnegra -format markdown -out kitsnegra.md
+// -resume をつけると既存のファイルの最後のメッセージから続きを追記する
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+
+	"github.com/fatih/color"
+	prs "github.com/kitsne241/go-qourier/persona"
+	"github.com/kitsne241/go-qourier/persona/export"
+)
+
+func main() {
+	path := flag.String("channel", "", "path of the channel to export (e.g. gps/times/kitsnegra)")
+	format := flag.String("format", "jsonl", "output format: jsonl, csv or markdown")
+	out := flag.String("out", "", "output file (default: stdout)")
+	resume := flag.Bool("resume", false, "append to the output file after its last exported message")
+	flag.Parse()
+
+	if *path == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if *resume && (*out == "") {
+		log.Fatalln(color.HiRedString("[failed to export] -resume requires -out"))
+	}
+
+	prs.SetUp(prs.Commands{}) // イベントは購読しないので、API を使うために Bot を作るだけ
+	ch := prs.PathGetChannel(*path)
+	if ch == nil {
+		log.Fatalln(color.HiRedString("[failed to export] channel #%s not found", *path))
+	}
+
+	opts := export.Options{Format: export.Format(*format)}
+	writer := os.Stdout
+	if *out != "" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if *resume {
+			if existing, err := os.Open(*out); err == nil {
+				opts.After, err = export.LastPosition(existing, opts.Format)
+				existing.Close()
+				if err != nil {
+					log.Fatalln(color.HiRedString("[failed to read %s] %s", *out, err))
+				}
+			}
+			if opts.After.ID != "" {
+				flags = os.O_WRONLY | os.O_APPEND
+			}
+		}
+
+		file, err := os.OpenFile(*out, flags, 0o644)
+		if err != nil {
+			log.Fatalln(color.HiRedString("[failed to open %s] %s", *out, err))
+		}
+		defer file.Close()
+		writer = file
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	pos, err := export.Channel(ctx, writer, ch, opts)
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to export #%s] %s (resume after %s)", ch.Path, err, pos.ID))
+		os.Exit(1) // 書き出しが途中で終わったことを呼び出し元のスクリプトにも伝える。書き出した分はファイルに書き込み済み
+	}
+	log.Println(color.GreenString("[exported #%s] last message: %s", ch.Path, pos.ID))
+}
@@ -1,6 +1,6 @@
 module github.com/kitsne241/go-qourier
 
-go 1.22.6
+go 1.23
 
 require (
 	github.com/fatih/color v1.18.0
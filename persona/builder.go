@@ -0,0 +1,189 @@
+package persona
+
+// Unembed の逆で、メッセージ中の埋め込みやその他の Markdown の記法を組み立てるための関数
+// ユーザーが入力した文字列などをそのまま連結すると、意図せず埋め込みや装飾として解釈されることがあるので Escape を通す
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// 埋め込みの JSON 文字列。Embed と違い、キーは type, raw, id の順に並べる
+func embed(kind string, raw string, id string) string {
+	text, _ := json.Marshal(struct {
+		Type string `json:"type"`
+		Raw  string `json:"raw"`
+		ID   string `json:"id"`
+	}{kind, raw, id})
+	return "!" + string(text)
+}
+
+// ユーザーへのメンションの埋め込み。"!{"type":"user","raw":"@kitsne","id":"..."}"
+func Mention(us *User) string {
+	if us == nil {
+		return ""
+	}
+	return embed("user", "@"+us.Name, us.ID)
+}
+
+// チャンネルリンクの埋め込み。"!{"type":"channel","raw":"#gps/times/kitsnegra","id":"..."}"
+func ChannelLink(ch *Channel) string {
+	if ch == nil {
+		return ""
+	}
+	if ch.IsDM() {
+		return Mention(ch.Partner) // DM チャンネルへのリンクは埋め込めないので相手へのメンションで代用する
+	}
+	return embed("channel", "#"+ch.Path, ch.ID)
+}
+
+// グループへのメンションの埋め込み。"!{"type":"group","raw":"@SysAd","id":"..."}"
+func GroupMention(gr *Group) string {
+	if gr == nil {
+		return ""
+	}
+	return embed("group", "@"+gr.Name, gr.ID)
+}
+
+// Markdown の記法や埋め込み、スタンプとして解釈されうる記号をバックスラッシュでエスケープする
+func Escape(text string) string {
+	escaped := strings.Builder{}
+	for _, r := range text {
+		if strings.ContainsRune("\\`*_{}[]()#+-.!|<>~:$", r) {
+			escaped.WriteRune('\\')
+		}
+		escaped.WriteRune(r)
+	}
+	return escaped.String()
+}
+
+// 埋め込みや装飾を含むメッセージテキストを組み立てる型。ゼロ値のまま使える
+// var mb prs.MessageBuilder
+// ms.Channel.Send(mb.Mention(user).Text(" さんの入力: ").Code(input).String())
+type MessageBuilder struct {
+	text strings.Builder
+}
+
+// エスケープした文字列を追加
+func (mb *MessageBuilder) Text(text string) *MessageBuilder {
+	mb.text.WriteString(Escape(text))
+	return mb
+}
+
+// エスケープせずに Markdown として追加
+func (mb *MessageBuilder) Raw(markdown string) *MessageBuilder {
+	mb.text.WriteString(markdown)
+	return mb
+}
+
+// 改行を追加
+func (mb *MessageBuilder) Line() *MessageBuilder {
+	mb.text.WriteString("\n")
+	return mb
+}
+
+// 太字の文字列を追加
+func (mb *MessageBuilder) Bold(text string) *MessageBuilder {
+	mb.text.WriteString("**" + Escape(text) + "**")
+	return mb
+}
+
+// ユーザーへのメンションを追加
+func (mb *MessageBuilder) Mention(us *User) *MessageBuilder {
+	mb.text.WriteString(Mention(us))
+	return mb
+}
+
+// チャンネルリンクを追加
+func (mb *MessageBuilder) Channel(ch *Channel) *MessageBuilder {
+	mb.text.WriteString(ChannelLink(ch))
+	return mb
+}
+
+// グループへのメンションを追加
+func (mb *MessageBuilder) Group(gr *Group) *MessageBuilder {
+	mb.text.WriteString(GroupMention(gr))
+	return mb
+}
+
+// スタンプを追加。"tada" に対して ":tada:"
+func (mb *MessageBuilder) Stamp(name string) *MessageBuilder {
+	mb.text.WriteString(":" + name + ":")
+	return mb
+}
+
+// インラインコードを追加。中身にバッククォートがあってもそれより長いバッククォートで囲む
+func (mb *MessageBuilder) Code(code string) *MessageBuilder {
+	fence := strings.Repeat("`", longestRun(code, '`')+1)
+	padding := ""
+	if strings.HasPrefix(code, "`") || strings.HasSuffix(code, "`") {
+		padding = " "
+	}
+	mb.text.WriteString(fence + padding + code + padding + fence)
+	return mb
+}
+
+// コードブロックを追加。lang は "go" などの言語名で、空でもよい
+func (mb *MessageBuilder) CodeBlock(lang string, code string) *MessageBuilder {
+	fence := strings.Repeat("`", max(3, longestRun(code, '`')+1))
+	mb.newParagraph()
+	mb.text.WriteString(fence + lang + "\n" + strings.TrimSuffix(code, "\n") + "\n" + fence + "\n")
+	return mb
+}
+
+// 表を追加。セルの中身はエスケープされ、改行は空白に置き換えられる
+func (mb *MessageBuilder) Table(header []string, rows [][]string) *MessageBuilder {
+	cell := func(text string) string {
+		return Escape(strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", " "), "\n", " "))
+	}
+	row := func(cells []string) string {
+		escaped := make([]string, len(header))
+		for i := range header {
+			if i < len(cells) {
+				escaped[i] = cell(cells[i])
+			}
+		}
+		return "| " + strings.Join(escaped, " | ") + " |\n"
+	}
+
+	mb.newParagraph()
+	mb.text.WriteString(row(header))
+	mb.text.WriteString("|" + strings.Repeat(" --- |", len(header)) + "\n")
+	for _, cells := range rows {
+		mb.text.WriteString(row(cells))
+	}
+	return mb
+}
+
+// クリックするまで隠される文字列（traQ の !!スポイラー!! 記法）を追加
+func (mb *MessageBuilder) Spoiler(text string) *MessageBuilder {
+	mb.text.WriteString("!!" + Escape(text) + "!!")
+	return mb
+}
+
+// 組み立てたメッセージテキスト
+func (mb *MessageBuilder) String() string {
+	return mb.text.String()
+}
+
+// コードブロックや表は行頭から始める必要があるので、途中であれば改行する
+func (mb *MessageBuilder) newParagraph() {
+	text := mb.text.String()
+	if (text != "") && !strings.HasSuffix(text, "\n") {
+		mb.text.WriteString("\n")
+	}
+}
+
+// 文字列中で同じ文字が連続する最大の長さ
+func longestRun(text string, target rune) int {
+	longest, current := 0, 0
+	for _, r := range text {
+		if r == target {
+			current++
+			longest = max(longest, current)
+		} else {
+			current = 0
+		}
+	}
+	return longest
+}
@@ -2,6 +2,7 @@ package persona
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
 	"time"
@@ -12,16 +13,23 @@ import (
 
 // traQ チャンネルを表す型
 type Channel struct {
-	Name   string   `json:"name"` // "kitsnegra"
-	Path   string   `json:"path"` // "gps/times/kitsnegra"
-	ID     string   `json:"id"`   // "019275db-f2fd-7922-81c9-956aab18612d"
-	Parent *Channel `json:"parent"`
+	Name    string   `json:"name"` // "kitsnegra"
+	Path    string   `json:"path"` // "gps/times/kitsnegra"
+	ID      string   `json:"id"`   // "019275db-f2fd-7922-81c9-956aab18612d"
+	Parent  *Channel `json:"parent"`
+	Partner *User    `json:"partner"` // DM チャンネルの場合のみ、会話の相手のユーザー
 }
 
+// traQ 内部で DM チャンネルの親として扱われている UUID
+const dmRootID = "aaaaaaaa-aaaa-4aaa-aaaa-aaaaaaaaaaaa"
+
 // 引数の UUID をもつチャンネルを取得
 func GetChannel(chID string) *Channel {
 	resp, _, err := Wsbot.API().ChannelApi.GetChannel(context.Background(), chID).Execute()
 	if err != nil {
+		if ch := dmGetChannel(chID); ch != nil {
+			return ch // DM チャンネルはパブリックチャンネルとしては取得できないことがあるので DM の一覧から探す
+		}
 		log.Println(color.HiYellowString("[failed to get channel in GetChannel(\"%s\")] %s", chID, err))
 		return nil
 	}
@@ -30,6 +38,9 @@ func GetChannel(chID string) *Channel {
 	parent := (*Channel)(nil)
 
 	parentID := resp.ParentId.Get()
+	if (parentID != nil) && (*parentID == dmRootID) {
+		return dmGetChannel(chID)
+	}
 	if parentID != nil { // resp.ParentId.IsSet() は常に true のようなので…
 		parent = GetChannel(*parentID) // 親チャンネルを得る
 		if parent == nil {
@@ -48,16 +59,38 @@ func GetChannel(chID string) *Channel {
 	}
 }
 
-// 引数のパスをもつチャンネルを取得
-func PathGetChannel(path string) *Channel {
-	channelPathID := getAllChannels().ID
-	chID, exists := channelPathID[path]
+// 引数の UUID をもつ DM チャンネルを取得。パスは "@相手のユーザー名" とする
+func dmGetChannel(chID string) *Channel {
+	usID, exists := getDMChannels().Symbol[chID]
 	if !exists {
-		log.Println(color.HiYellowString("[failed to get channel in PathGetChannel(\"%s\")] not found such channel", path))
 		return nil
 	}
+	user := GetUser(usID)
+	if user == nil {
+		return nil
+	}
+	return &Channel{
+		Name:    user.Name,
+		Path:    "@" + user.Name,
+		ID:      chID,
+		Partner: user,
+	}
+}
+
+// DM チャンネルであるかどうか
+func (ch *Channel) IsDM() bool {
+	return (ch != nil) && (ch.Partner != nil)
+}
+
+// 引数のパスをもつチャンネルを取得
+func PathGetChannel(path string) *Channel {
 	// チャンネルの path（"gps/times/kitsnegra" とか）から *Channel 型を得る
-	return GetChannel(chID)
+	// 親チャンネルも木構造から得られるので、GetChannel で祖先を辿り直す必要はない
+	ch := getAllChannels().Path(path)
+	if ch == nil {
+		log.Println(color.HiYellowString("[failed to get channel in PathGetChannel(\"%s\")] not found such channel", path))
+	}
+	return ch
 }
 
 // 子チャンネルの配列を取得
@@ -65,122 +98,58 @@ func (ch *Channel) GetChildren() []*Channel {
 	if ch == nil {
 		return []*Channel{}
 	}
-	resp, _, err := Wsbot.API().ChannelApi.GetChannel(context.Background(), ch.ID).Execute()
-	if err != nil {
-		log.Println(color.HiYellowString("[failed to get children of #%s in GetChildren()] %s", ch.Path, err))
-		return []*Channel{}
-	}
-
-	children := []*Channel{}
-	for _, child := range resp.Children {
-		if c := GetChannel(child); c != nil {
-			children = append(children, c)
-		}
-	}
-	return children
+	// 子チャンネルごとに GetChannel を呼ぶと子の数だけ祖先を辿り直すことになるので、木構造から得る
+	return getAllChannels().Children(ch)
 }
 
 // 最新のメッセージを引数個取得
 func (ch *Channel) GetRecentMessages(limit int) []*Message {
 	// 一番新しい投稿が配列の [0] になる
-	if ch == nil {
-		return []*Message{}
+	messages := []*Message{}
+	if (ch == nil) || (limit <= 0) {
+		return messages
 	}
-	// resp, _, err := Wsbot.API().ChannelApi.GetMessages(context.Background(), ch.ID).Limit(limit).Execute()
-	// if err != nil {
-	// 	cp.CPrintf("[failed to get recent messages in GetRecentMessages(%d)] %s\nch = %v", limit, err, ch)
-	// 	return []*Message{}
-	// }
 
-	respAll := make([]traq.Message, 3000) // 上限はとりあえず 3000 とする
-	for i := 0; i*150 < limit; i++ {
-		resp, _, err := Wsbot.API().ChannelApi.GetMessages(context.Background(), ch.ID).
-			Limit(int32(150)).Offset(int32(150 * i)).Execute()
+	for ms, err := range ch.Messages(context.Background(), HistoryOptions{}) {
 		if err != nil {
 			log.Println(color.HiYellowString(
 				"[failed to get recent messages on #%s in GetRecentMessages(%d)] %s", ch.Path, limit, err,
 			))
 			return []*Message{}
 		}
-		for j, res := range resp {
-			respAll[i*150+j] = res
-		}
-		if len(resp) < 150 {
-			respAll = respAll[:i*150+len(resp)] // 取りうる数の上限で respAll の長さを再規定
+		messages = append(messages, ms)
+		if len(messages) == limit {
 			break
 		}
 	}
-	if len(respAll) > limit {
-		respAll = respAll[:limit] // ユーザーが指定した limit で respAll の長さを再規定
-	}
-
-	// もともとの ChannelApi.GetMessages の仕様として、
-	// 一度に 200 以上メッセージを読み込もうとすると失敗して 400 Bad Request が返るので 150 刻みに取得するように設計
-
-	jst, err := time.LoadLocation("Asia/Tokyo")
-	if err != nil {
-		log.Println(color.HiYellowString("[failed to load location in GetRecentMessages(%d)] %s", limit, err))
-		return nil
-	}
-
-	// 連続して API にアクセスすると失敗するので、こちらでは GetMessage は使っていない。書き換え時注意！
-
-	userDic := map[string]*User{}
-	// ユーザーの UUID と User 型との対応の辞書
-	// 同じユーザーに対して何度も GetUser をするのは処理の無駄が激しく API の制限も受けやすいので、
-	// 一時的に情報を保存の上再利用して制限を回避する
+	return messages
+}
 
-	addUser := func(userId string) { // 与えられた UUID をもつユーザーがまだ userDic になければ追加する
-		_, exists := userDic[userId]
-		if !exists {
-			user := GetUser(userId)
-			if user != nil {
-				userDic[userId] = user
-			}
-		}
+// チャンネルにメッセージを送信
+// MaxMessageLength を超える長さのメッセージは段落や行の区切りで分割して順に投稿し、投稿された全てのメッセージを返す
+func (ch *Channel) Send(content string) []*Message {
+	if ch == nil {
+		return []*Message{}
 	}
-
-	stampIDName := getAllStamps().Symbol
-
-	messages := make([]*Message, len(respAll))
-	for i, message := range respAll {
-		addUser(message.UserId)
-
-		stamps := []*Stamp{}
-		for _, mstamp := range message.Stamps {
-			addUser(mstamp.UserId)
-			stamps = append(stamps, &Stamp{
-				Name:  stampIDName[mstamp.StampId],
-				ID:    mstamp.StampId,
-				User:  userDic[mstamp.UserId],
-				Count: int(mstamp.Count),
-			})
-		}
-
-		messages[i] = &Message{
-			Channel:   ch,
-			Text:      message.Content,
-			ID:        message.Id,
-			CreatedAt: message.CreatedAt.In(jst),
-			UpdatedAt: message.UpdatedAt.In(jst),
-			Author:    userDic[message.UserId],
-			Stamps:    stamps,
+	messages := []*Message{}
+	for _, part := range splitMessage(content, MaxMessageLength) {
+		ms, err := ch.post(part)
+		if err != nil {
+			log.Println(color.HiYellowString("[failed to send message on #%s in Send()] %s", ch.Path, err))
+			break // 途中の部分が欠けた状態で続きを投稿しても読めないので、残りは投稿しない
 		}
+		messages = append(messages, ms)
 	}
-
 	return messages
 }
 
-// チャンネルにメッセージを送信
-func (ch *Channel) Send(content string) {
-	if ch == nil {
-		return
-	}
+// チャンネルにメッセージを投稿し、投稿されたメッセージを返す
+func (ch *Channel) post(content string) (*Message, error) {
 	if content == "" {
-		log.Println(color.HiYellowString("[failed to send message on #%s in Send()] message is empty", ch.Path))
-		return // 空白のメッセージは 400 Bad Request で弾かれるが、原因究明の手間を省くためにエラーメッセージ付でここで弾いてしまう
+		return nil, fmt.Errorf("message is empty")
+		// 空白のメッセージは 400 Bad Request で弾かれるが、原因究明の手間を省くためにエラーメッセージ付でここで弾いてしまう
 	}
-	_, _, err := Wsbot.API().MessageApi.PostMessage(context.Background(), ch.ID).
+	resp, _, err := Wsbot.API().MessageApi.PostMessage(context.Background(), ch.ID).
 		PostMessageRequest(traq.PostMessageRequest{Content: content}).Execute()
 
 	// traq-ws-bot を使わない場合、
@@ -188,8 +157,24 @@ func (ch *Channel) Send(content string) {
 	// _, _, err := apiClient.MessageApi.以下略
 
 	if err != nil {
-		log.Println(color.HiYellowString("[failed to send message on #%s in Send()] %s", ch.Path, err))
+		return nil, err
 	}
+
+	jst, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load location: %w", err)
+	}
+
+	// 投稿したばかりのメッセージなのでスタンプはついていない。GetMessage を呼ばずに組み立てる
+	return &Message{
+		Channel:   ch,
+		Text:      resp.Content,
+		ID:        resp.Id,
+		CreatedAt: resp.CreatedAt.In(jst),
+		UpdatedAt: resp.UpdatedAt.In(jst),
+		Author:    getMe(),
+		Stamps:    []*Stamp{},
+	}, nil
 }
 
 // チャンネルに参加（メンション以外の投稿イベントを購読）する
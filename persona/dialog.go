@@ -0,0 +1,270 @@
+package persona
+
+// チャンネルとユーザーの組ごとに進行する、複数回のやり取りにわたる対話の仕組み
+// 対話は名前のついた状態機械（Flow）で、現在の状態と集めた値は capsule に保存されるので Bot を再起動しても続きから再開できる
+// capsule がデータベースに接続されていなければメモリ上にのみ保存する
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	cps "github.com/kitsne241/go-qourier/capsule"
+)
+
+// 対話のある状態で、届いたメッセージを処理する関数
+// 処理の中で dg.Goto(次の状態) や dg.End() を呼んで対話を進める。何も呼ばなければ同じ状態に留まる
+// dg は保存されている対話の複製で、エラーを返さなかった場合に限りその内容が保存される
+type Step func(ms *Message, dg *Dialog) error
+
+// 状態の名前とその状態で実行する関数の対応
+type Flow map[string]Step
+
+// 進行中の対話
+type Dialog struct {
+	Flow      string            `json:"flow"`      // RegisterFlow で登録した名前
+	State     string            `json:"state"`     // 現在の状態
+	Values    map[string]string `json:"values"`    // 対話の中で集めた値
+	ChannelID string            `json:"channelid"` // 対話が行われているチャンネルの UUID
+	UserID    string            `json:"userid"`    // 対話の相手の UUID
+	UpdatedAt time.Time         `json:"updatedat"`
+
+	ended bool
+}
+
+var (
+	flows   = map[string]Flow{}
+	flowsMu sync.RWMutex
+
+	dialogs     = map[string]*Dialog{}     // 進行中の全ての対話。capsule に保存されたものは最初に参照したときにまとめて読み出す
+	dialogsRead bool                       // capsule に保存された対話を読み出し終えたか
+	dialogLocks = map[string]*dialogLock{} // 対話ごとの排他制御。同じ投稿者の複数のメッセージを同時に処理しないようにする
+	dialogsMu   sync.Mutex
+)
+
+// 対話ごとの排他制御と、それを待っている呼び出しの数。誰も待っていなければ dialogLocks から消す
+type dialogLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// 対話の流れを名前をつけて登録する
+func RegisterFlow(name string, flow Flow) {
+	if len(flow) == 0 {
+		panic(color.HiRedString("[failed to register flow '%s'] flow has no state", name))
+	}
+	flowsMu.Lock()
+	defer flowsMu.Unlock()
+	flows[name] = flow
+}
+
+// メッセージの投稿者との対話を、そのチャンネルで引数の状態から始める。進行中の対話があれば置き換える
+func (ms *Message) Begin(flow string, state string) error {
+	if (ms == nil) || (ms.Author == nil) {
+		return fmt.Errorf("message is nil")
+	}
+
+	flowsMu.RLock()
+	steps, exists := flows[flow]
+	flowsMu.RUnlock()
+	if !exists {
+		return fmt.Errorf("flow '%s' is not registered", flow)
+	}
+	if _, exists := steps[state]; !exists {
+		return fmt.Errorf("flow '%s' does not have state '%s'", flow, state)
+	}
+
+	saveDialog(&Dialog{
+		Flow:      flow,
+		State:     state,
+		Values:    map[string]string{},
+		ChannelID: ms.Channel.ID,
+		UserID:    ms.Author.ID,
+		UpdatedAt: time.Now(),
+	})
+	return nil
+}
+
+// 次のメッセージを受け取ったときの状態を指定する。Flow にない状態ならエラーを返し、状態は変わらない
+func (dg *Dialog) Goto(state string) error {
+	flowsMu.RLock()
+	_, exists := flows[dg.Flow][state]
+	flowsMu.RUnlock()
+	if !exists {
+		return fmt.Errorf("flow '%s' does not have state '%s'", dg.Flow, state)
+	}
+	dg.State = state
+	return nil
+}
+
+// 対話を終了する
+func (dg *Dialog) End() {
+	dg.ended = true
+}
+
+// チャンネルでユーザーと進行中の対話を取得する。なければ nil
+func GetDialog(ch *Channel, us *User) *Dialog {
+	if (ch == nil) || (us == nil) {
+		return nil
+	}
+	if dg := loadDialog(dialogKey(ch.ID, us.ID)); dg != nil {
+		return dg.clone() // 返した対話を書き換えられても保存されている対話には影響しない
+	}
+	return nil
+}
+
+// チャンネルでユーザーと進行中の対話を中断する
+func CancelDialog(ch *Channel, us *User) {
+	if (ch == nil) || (us == nil) {
+		return
+	}
+	dropDialog(dialogKey(ch.ID, us.ID))
+}
+
+// メッセージの投稿者と進行中の対話があれば、現在の状態の関数に渡して true を返す
+func continueDialog(ms *Message) bool {
+	if (ms.Channel == nil) || (ms.Author == nil) {
+		return false
+	}
+
+	key := dialogKey(ms.Channel.ID, ms.Author.ID)
+	unlock := lockDialog(key) // 読み出しから保存までの間に同じ対話の別のメッセージを処理しない
+	defer unlock()
+
+	dg := loadDialog(key)
+	if dg == nil {
+		return false
+	}
+
+	flowsMu.RLock()
+	step, exists := flows[dg.Flow][dg.State]
+	flowsMu.RUnlock()
+	if !exists {
+		// 再起動の前後で Flow の定義が変わった場合など。続けようがないので対話を破棄する
+		log.Println(color.HiYellowString("[failed to continue dialog '%s'] state '%s' not found", dg.Flow, dg.State))
+		dropDialog(key)
+		return false
+	}
+
+	// 関数には複製を渡し、成功した場合に限り保存する
+	next := dg.clone()
+	if err := step(ms, next); err != nil {
+		// 失敗した場合は状態を進めず、同じ状態で次のメッセージを待つ
+		log.Println(color.HiYellowString("[failed to run dialog '%s' at '%s'] %s", dg.Flow, dg.State, err))
+		return true
+	}
+	if loadDialog(key) != dg {
+		return true // 関数の中で Begin や CancelDialog により対話が置き換えられた場合はそちらを優先する
+	}
+
+	if next.ended {
+		dropDialog(key)
+	} else {
+		next.UpdatedAt = time.Now()
+		saveDialog(next)
+	}
+	return true
+}
+
+// 対話の排他制御を取得し、解放する関数を返す
+func lockDialog(key string) func() {
+	dialogsMu.Lock()
+	lock, exists := dialogLocks[key]
+	if !exists {
+		lock = &dialogLock{}
+		dialogLocks[key] = lock
+	}
+	lock.waiters++
+	dialogsMu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		dialogsMu.Lock()
+		if lock.waiters--; lock.waiters == 0 {
+			delete(dialogLocks, key)
+		}
+		dialogsMu.Unlock()
+	}
+}
+
+func (dg *Dialog) clone() *Dialog {
+	copied := *dg
+	copied.Values = make(map[string]string, len(dg.Values))
+	for key, value := range dg.Values {
+		copied.Values[key] = value
+	}
+	return &copied
+}
+
+func dialogKey(chID string, usID string) string {
+	return "dialog/" + chID + "/" + usID
+}
+
+func loadDialog(key string) *Dialog {
+	dialogsMu.Lock()
+	defer dialogsMu.Unlock()
+
+	// 全てのメッセージがここを通るので、メッセージごとにデータベースを参照しないよう最初に一度だけまとめて読み出す
+	if !dialogsRead {
+		readDialogs()
+	}
+	return dialogs[key]
+}
+
+// capsule に保存された対話を全て読み出す。dialogsMu を取得した状態で呼ぶ
+func readDialogs() {
+	if cps.Db == nil {
+		dialogsRead = true
+		return
+	}
+	names, err := cps.RecordNames("dialog/")
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to load dialogs] %s", err)) // 次に参照したときに読み出し直す
+		return
+	}
+	for _, name := range names {
+		if _, exists := dialogs[name]; exists {
+			continue // 読み出す前に始まった対話のほうが新しい
+		}
+		dg, exists, err := cps.LoadRecord[*Dialog](name)
+		if err != nil {
+			log.Println(color.HiYellowString("[failed to load dialog %s] %s", name, err))
+			continue
+		}
+		if exists {
+			dialogs[name] = dg
+		}
+	}
+	dialogsRead = true
+}
+
+func saveDialog(dg *Dialog) {
+	key := dialogKey(dg.ChannelID, dg.UserID)
+
+	dialogsMu.Lock()
+	defer dialogsMu.Unlock()
+
+	dialogs[key] = dg
+	if cps.Db == nil {
+		return
+	}
+	if err := cps.SaveRecord(key, dg); err != nil {
+		log.Println(color.HiYellowString("[failed to save dialog %s] %s", key, err))
+	}
+}
+
+func dropDialog(key string) {
+	dialogsMu.Lock()
+	defer dialogsMu.Unlock()
+
+	delete(dialogs, key)
+	if cps.Db == nil {
+		return
+	}
+	if err := cps.DeleteRecord(key); err != nil {
+		log.Println(color.HiYellowString("[failed to delete dialog %s] %s", key, err))
+	}
+}
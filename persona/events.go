@@ -0,0 +1,212 @@
+package persona
+
+// traq-ws-bot が提供するイベントのうち、新規メッセージとスタンプ以外のもの
+// いずれも生のペイロードではなく *Message・*Channel・*User などに変換してから関数に渡す
+// ペイロードに含まれる情報で足りる場合は API を呼ばずに変換する
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"github.com/fatih/color"
+	event "github.com/traPtitech/traq-ws-bot/event"
+	payload "github.com/traPtitech/traq-ws-bot/payload"
+)
+
+// メッセージが編集されたときに呼ばれる関数。DM も含む。Bot が参加しているチャンネルのみ
+var OnMessageUpdated func(*Message)
+
+// メッセージが削除されたときに呼ばれる関数。DM も含む。削除済みなので ID と Channel 以外は空
+var OnMessageDeleted func(*Message)
+
+// Bot がチャンネルに参加・脱退したときに呼ばれる関数
+var OnJoined, OnLeft func(*Channel)
+
+// チャンネルが作成されたときに呼ばれる関数
+var OnChannelCreated func(*Channel)
+
+// チャンネルのトピックが変更されたときに呼ばれる関数。変更後のトピックと変更したユーザーを受け取る
+var OnTopicChanged func(ch *Channel, topic string, updater *User)
+
+// ユーザーが作成・凍結解除されたときに呼ばれる関数
+var OnUserCreated, OnUserActivated func(*User)
+
+// スタンプが作成されたときに呼ばれる関数。Count と User は無意味な値で、作成したユーザーは第 2 引数で受け取る
+var OnStampCreated func(st *Stamp, creator *User)
+
+// Bot 自身にタグがつけられた・外されたときに呼ばれる関数
+var OnTagAdded, OnTagRemoved func(tag string)
+
+// グループが作成・更新されたときに呼ばれる関数
+var OnGroupCreated, OnGroupUpdated func(*Group)
+
+// グループが削除されたときに呼ばれる関数。削除済みなので ID 以外は空
+var OnGroupDeleted func(*Group)
+
+// グループのメンバー・管理者が変化したときに呼ばれる関数
+var OnGroupMemberAdded, OnGroupMemberUpdated, OnGroupMemberRemoved func(*Group, *User)
+var OnGroupAdminAdded, OnGroupAdminRemoved func(*Group, *User)
+
+// SetUp の中で全てのイベントを購読する。関数が nil のイベントは受け取っても何もしない
+func subscribeEvents() {
+	Wsbot.OnMessageUpdated(func(p *payload.MessageUpdated) {
+		messageUpdated(p.Message.ID)
+	})
+	Wsbot.OnDirectMessageUpdated(func(p *payload.DirectMessageUpdated) {
+		messageUpdated(p.Message.ID)
+	})
+
+	Wsbot.OnMessageDeleted(func(p *payload.MessageDeleted) {
+		messageDeleted(p.Message.ID, p.Message.ChannelID)
+	})
+	Wsbot.OnDirectMessageDeleted(func(p *payload.DirectMessageDeleted) {
+		messageDeleted(p.Message.ID, p.Message.ChannelID)
+	})
+
+	Wsbot.OnJoined(func(p *payload.Joined) {
+		if OnJoined != nil {
+			OnJoined(channelFromPayload(p.Channel))
+		}
+	})
+	Wsbot.OnLeft(func(p *payload.Left) {
+		if OnLeft != nil {
+			OnLeft(channelFromPayload(p.Channel))
+		}
+	})
+	Wsbot.OnChannelCreated(func(p *payload.ChannelCreated) {
+		if OnChannelCreated != nil {
+			OnChannelCreated(channelFromPayload(p.Channel))
+		}
+	})
+	Wsbot.OnChannelTopicChanged(func(p *payload.ChannelTopicChanged) {
+		if OnTopicChanged != nil {
+			OnTopicChanged(channelFromPayload(p.Channel), p.Topic, userFromPayload(p.Updater))
+		}
+	})
+
+	Wsbot.OnUserCreated(func(p *payload.UserCreated) {
+		if OnUserCreated != nil {
+			OnUserCreated(userFromPayload(p.User))
+		}
+	})
+	Wsbot.OnUserActivated(func(p *payload.UserActivated) {
+		if OnUserActivated != nil {
+			OnUserActivated(userFromPayload(p.User))
+		}
+	})
+	Wsbot.OnStampCreated(func(p *payload.StampCreated) {
+		if OnStampCreated != nil {
+			OnStampCreated(&Stamp{Name: p.Name, ID: p.ID}, userFromPayload(p.Creator))
+		}
+	})
+
+	Wsbot.OnTagAdded(func(p *payload.TagAdded) {
+		if OnTagAdded != nil {
+			OnTagAdded(p.Tag)
+		}
+	})
+	Wsbot.OnTagRemoved(func(p *payload.TagRemoved) {
+		if OnTagRemoved != nil {
+			OnTagRemoved(p.Tag)
+		}
+	})
+
+	// 利用している traq-ws-bot にはグループのイベント専用の関数がないので OnEvent から直接購読する
+	onEvent(event.UserGroupCreated, func(p *payload.UserGroupCreated) {
+		if OnGroupCreated != nil {
+			OnGroupCreated(&Group{Name: p.Group.Name, ID: p.Group.ID, Description: p.Group.Description, Type: p.Group.Type})
+		}
+	})
+	onEvent(event.UserGroupUpdated, func(p *payload.UserGroupUpdated) {
+		if OnGroupUpdated != nil {
+			if group := GetGroup(p.GroupID); group != nil {
+				OnGroupUpdated(group)
+			}
+		}
+	})
+	onEvent(event.UserGroupDeleted, func(p *payload.UserGroupDeleted) {
+		if OnGroupDeleted != nil {
+			OnGroupDeleted(&Group{ID: p.GroupID})
+		}
+	})
+
+	onEvent(event.UserGroupMemberAdded, func(p *payload.UserGroupMemberAdded) {
+		groupMemberChanged(OnGroupMemberAdded, p.GroupMember)
+	})
+	onEvent(event.UserGroupMemberUpdated, func(p *payload.UserGroupMemberUpdated) {
+		groupMemberChanged(OnGroupMemberUpdated, p.GroupMember)
+	})
+	onEvent(event.UserGroupMemberRemoved, func(p *payload.UserGroupMemberRemoved) {
+		groupMemberChanged(OnGroupMemberRemoved, p.GroupMember)
+	})
+	onEvent(event.UserGroupAdminAdded, func(p *payload.UserGroupAdminAdded) {
+		groupMemberChanged(OnGroupAdminAdded, p.GroupMember)
+	})
+	onEvent(event.UserGroupAdminRemoved, func(p *payload.UserGroupAdminRemoved) {
+		groupMemberChanged(OnGroupAdminRemoved, p.GroupMember)
+	})
+}
+
+// 任意のイベントについて、ペイロードを型 P に変換してから関数に渡すように購読する
+func onEvent[P any](name string, action func(p *P)) {
+	Wsbot.OnEvent(name, func(raw json.RawMessage) {
+		p := new(P)
+		if err := json.Unmarshal(raw, p); err != nil {
+			log.Println(color.HiYellowString("[failed to decode %s event] %s", name, err))
+			return
+		}
+		action(p)
+	})
+}
+
+func messageUpdated(msID string) {
+	if (OnMessageUpdated == nil) && !(RerunOnEdit && isTracked(msID)) {
+		return
+	}
+	ms := GetMessage(msID)
+	if ms == nil {
+		return
+	}
+	rerun(ms)
+	if OnMessageUpdated != nil {
+		OnMessageUpdated(ms)
+	}
+}
+
+func messageDeleted(msID string, chID string) {
+	if OnMessageDeleted == nil {
+		return
+	}
+	// 削除されたメッセージは GetMessage で取得できないので、分かる情報だけで組み立てる
+	OnMessageDeleted(&Message{Channel: GetChannel(chID), ID: msID, Stamps: []*Stamp{}})
+}
+
+func groupMemberChanged(action func(*Group, *User), member payload.GroupMember) {
+	if action == nil {
+		return
+	}
+	group := GetGroup(member.GroupID)
+	user := GetUser(member.UserID)
+	if (group == nil) || (user == nil) {
+		return
+	}
+	action(group, user)
+}
+
+// ペイロードのチャンネルには親チャンネルの情報が含まれないので、他のチャンネルと同様に GetChannel で取得する
+func channelFromPayload(p payload.Channel) *Channel {
+	if ch := GetChannel(p.ID); ch != nil {
+		return ch
+	}
+	return &Channel{Name: p.Name, Path: strings.TrimPrefix(p.Path, "#"), ID: p.ID}
+}
+
+func userFromPayload(p payload.User) *User {
+	return &User{
+		Nick:  p.DisplayName,
+		Name:  p.Name,
+		ID:    p.ID,
+		IsBot: p.Bot,
+	}
+}
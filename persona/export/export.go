@@ -0,0 +1,279 @@
+package export
+
+// チャンネルの全履歴をファイルに書き出すための関数
+// 書き出しは古い順に行い、途中で止まっても最後に書き出したメッセージの位置から再開できる
+// JSON Lines・CSV は機械で読むため、Markdown は人が読むための形式
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	prs "github.com/kitsne241/go-qourier/persona"
+)
+
+// 書き出しの形式
+type Format string
+
+const (
+	JSONL    Format = "jsonl"
+	CSV      Format = "csv"
+	Markdown Format = "markdown"
+)
+
+// 書き出しの設定
+type Options struct {
+	Format Format
+	After  Position // この位置より後に投稿されたメッセージから書き出す。ゼロ値なら最初から
+}
+
+// 書き出しを再開するための位置
+type Position struct {
+	ID        string    // 最後に書き出したメッセージの UUID
+	CreatedAt time.Time // 最後に書き出したメッセージの投稿日時
+	Written   []string  // CreatedAt ちょうどに投稿された、書き出し済みのメッセージの UUID（ID を含む）
+}
+
+// JSON Lines の 1 行に対応する型
+type Record struct {
+	ID        string        `json:"id"`
+	Channel   string        `json:"channel"` // "gps/times/kitsnegra"
+	Author    string        `json:"author"`  // "kitsne"
+	Nick      string        `json:"nick"`    // "きつね"
+	IsBot     bool          `json:"isbot"`
+	Text      string        `json:"text"` // 埋め込みを解消したメッセージテキスト
+	Raw       string        `json:"raw"`  // もとのメッセージテキスト
+	CreatedAt time.Time     `json:"createdat"`
+	UpdatedAt time.Time     `json:"updatedat"`
+	Stamps    []StampRecord `json:"stamps"`
+}
+
+// メッセージについたスタンプ
+type StampRecord struct {
+	Name  string `json:"name"` // "tada"
+	User  string `json:"user"` // "kitsne"
+	Count int    `json:"count"`
+}
+
+var csvHeader = []string{"id", "channel", "author", "nick", "isbot", "text", "createdat", "updatedat", "stamps"}
+
+// Markdown の各メッセージの末尾に置き、再開の際に最後のメッセージを見つけるための印
+var markdownID = regexp.MustCompile(`<!-- id: ([0-9a-fA-F-]{36})(?: at: (\S+))? -->`)
+
+// チャンネルの履歴を古い順に w に書き出し、最後に書き出した位置を返す
+// 途中で失敗した場合もそれまでに書き出した位置を返すので、opts.After に渡して再開できる
+func Channel(ctx context.Context, w io.Writer, ch *prs.Channel, opts Options) (Position, error) {
+	pos := opts.After
+	if ch == nil {
+		return pos, fmt.Errorf("channel is nil")
+	}
+	if !slices.Contains([]Format{JSONL, CSV, Markdown}, opts.Format) {
+		return pos, fmt.Errorf("unknown format '%s'", opts.Format)
+	}
+
+	resuming := (pos.ID != "")
+	if resuming && pos.CreatedAt.IsZero() {
+		// 投稿日時の分からない古い形式の印から再開する場合に限り、メッセージを取得して投稿日時を得る
+		after := prs.GetMessage(pos.ID)
+		if after == nil {
+			return pos, fmt.Errorf("message %s to resume from not found", pos.ID)
+		}
+		pos.CreatedAt = after.CreatedAt
+	}
+	if resuming && !slices.Contains(pos.Written, pos.ID) {
+		pos.Written = append(slices.Clone(pos.Written), pos.ID)
+	}
+
+	// 同じ投稿日時のメッセージを取りこぼさないよう境界を含めて取得し、書き出し済みのものは読み飛ばす
+	// 再開の起点のメッセージが削除されていても、投稿日時さえ分かれば再開できる
+	history := prs.HistoryOptions{Order: "asc", Since: pos.CreatedAt, Inclusive: true}
+
+	buffered := bufio.NewWriter(w)
+	csvWriter := csv.NewWriter(buffered)
+
+	// 再開する場合は既存のファイルに追記するものとして、見出しは書かない
+	if !resuming {
+		switch opts.Format {
+		case CSV:
+			csvWriter.Write(csvHeader)
+		case Markdown:
+			fmt.Fprintf(buffered, "# #%s\n\n", ch.Path)
+		}
+	}
+
+	for ms, err := range ch.Messages(ctx, history) {
+		if err != nil {
+			csvWriter.Flush()
+			buffered.Flush()
+			return pos, err
+		}
+		if ms.CreatedAt.Equal(pos.CreatedAt) && slices.Contains(pos.Written, ms.ID) {
+			continue
+		}
+
+		record := toRecord(ms)
+		switch opts.Format {
+		case JSONL:
+			line, err := json.Marshal(record)
+			if err != nil {
+				return pos, fmt.Errorf("failed to marshal message %s: %w", ms.ID, err)
+			}
+			buffered.Write(append(line, '\n'))
+		case CSV:
+			csvWriter.Write([]string{
+				record.ID, record.Channel, record.Author, record.Nick, strconv.FormatBool(record.IsBot), record.Text,
+				record.CreatedAt.Format(time.RFC3339Nano), record.UpdatedAt.Format(time.RFC3339Nano), stampSummary(record.Stamps),
+			})
+		case Markdown:
+			writeMarkdown(buffered, record)
+		}
+
+		csvWriter.Flush()
+		if err := csvWriter.Error(); err != nil {
+			return pos, fmt.Errorf("failed to write message %s: %w", ms.ID, err)
+		}
+		if err := buffered.Flush(); err != nil {
+			return pos, fmt.Errorf("failed to write message %s: %w", ms.ID, err)
+		}
+		pos = pos.advance(ms.ID, ms.CreatedAt) // 書き出しに成功したメッセージだけを再開の起点にする
+	}
+	return pos, nil
+}
+
+// 以前に書き出したファイルから、最後に書き出した位置を読み取る。メッセージがなければゼロ値
+func LastPosition(r io.Reader, format Format) (Position, error) {
+	pos := Position{}
+	switch format {
+	case JSONL:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024) // 長いメッセージの行にも対応する
+		for scanner.Scan() {
+			if strings.TrimSpace(scanner.Text()) == "" {
+				continue
+			}
+			record := Record{}
+			if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+				return Position{}, fmt.Errorf("failed to unmarshal %s: %w", scanner.Text(), err)
+			}
+			pos = pos.advance(record.ID, record.CreatedAt)
+		}
+		return pos, scanner.Err()
+
+	case CSV:
+		reader := csv.NewReader(r)
+		rows, err := reader.ReadAll()
+		if err != nil {
+			return Position{}, fmt.Errorf("failed to read csv: %w", err)
+		}
+		for _, row := range rows {
+			if slices.Equal(row, csvHeader) || (len(row) != len(csvHeader)) {
+				continue
+			}
+			createdAt, err := time.Parse(time.RFC3339Nano, row[6])
+			if err != nil {
+				return Position{}, fmt.Errorf("failed to parse createdat of %s: %w", row[0], err)
+			}
+			pos = pos.advance(row[0], createdAt)
+		}
+		return pos, nil
+
+	case Markdown:
+		text, err := io.ReadAll(r)
+		if err != nil {
+			return Position{}, err
+		}
+		for _, match := range markdownID.FindAllStringSubmatch(string(text), -1) {
+			createdAt := time.Time{} // 投稿日時のない古い形式の印なら、再開の際にメッセージから取得する
+			if match[2] != "" {
+				if createdAt, err = time.Parse(time.RFC3339Nano, match[2]); err != nil {
+					return Position{}, fmt.Errorf("failed to parse createdat of %s: %w", match[1], err)
+				}
+			}
+			pos = pos.advance(match[1], createdAt)
+		}
+		return pos, nil
+	}
+	return Position{}, fmt.Errorf("unknown format '%s'", format)
+}
+
+// 次のメッセージを書き出した後の位置を返す
+func (pos Position) advance(id string, createdAt time.Time) Position {
+	if createdAt.Equal(pos.CreatedAt) && (pos.ID != "") {
+		return Position{ID: id, CreatedAt: pos.CreatedAt, Written: append(slices.Clone(pos.Written), id)}
+	}
+	return Position{ID: id, CreatedAt: createdAt, Written: []string{id}}
+}
+
+func toRecord(ms *prs.Message) Record {
+	text, _ := prs.Unembed(ms.Text)
+	record := Record{
+		ID:        ms.ID,
+		Text:      text,
+		Raw:       ms.Text,
+		CreatedAt: ms.CreatedAt,
+		UpdatedAt: ms.UpdatedAt,
+		Stamps:    []StampRecord{},
+	}
+	if ms.Channel != nil {
+		record.Channel = ms.Channel.Path
+	}
+	if ms.Author != nil {
+		record.Author, record.Nick, record.IsBot = ms.Author.Name, ms.Author.Nick, ms.Author.IsBot
+	}
+	for _, st := range ms.Stamps {
+		stamp := StampRecord{Name: st.Name, Count: st.Count}
+		if st.User != nil {
+			stamp.User = st.User.Name
+		}
+		record.Stamps = append(record.Stamps, stamp)
+	}
+	return record
+}
+
+// "tada:kitsne×2 done-nya:kitsne" のようにスタンプをまとめる
+func stampSummary(stamps []StampRecord) string {
+	parts := []string{}
+	for _, st := range stamps {
+		part := st.Name + ":" + st.User
+		if st.Count > 1 {
+			part += "×" + strconv.Itoa(st.Count)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, " ")
+}
+
+func writeMarkdown(w io.Writer, record Record) {
+	author := "@" + record.Author
+	if record.Nick != "" {
+		author += " (" + record.Nick + ")"
+	}
+	fmt.Fprintf(w, "### %s — %s\n\n", author, record.CreatedAt.Format("2006/01/02 15:04:05"))
+	fmt.Fprintf(w, "%s\n\n", record.Text)
+
+	// スタンプはスタンプごとに押したユーザーをまとめる
+	names := []string{}
+	users := map[string][]string{}
+	for _, st := range record.Stamps {
+		if _, exists := users[st.Name]; !exists {
+			names = append(names, st.Name)
+		}
+		users[st.Name] = append(users[st.Name], st.User)
+	}
+	if len(names) > 0 {
+		reactions := []string{}
+		for _, name := range names {
+			reactions = append(reactions, fmt.Sprintf(":%s: %s", name, strings.Join(users[name], ", ")))
+		}
+		fmt.Fprintf(w, "> %s\n\n", strings.Join(reactions, " / "))
+	}
+	fmt.Fprintf(w, "<!-- id: %s at: %s -->\n\n---\n\n", record.ID, record.CreatedAt.Format(time.RFC3339Nano))
+}
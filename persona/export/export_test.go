@@ -0,0 +1,148 @@
+package export
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	id1 = "0192d23e-2fb1-764b-ba7d-dbabd1185e01"
+	id2 = "0192d23e-2fb1-764b-ba7d-dbabd1185e02"
+	id3 = "0192d23e-2fb1-764b-ba7d-dbabd1185e03"
+)
+
+var (
+	time1 = time.Date(2024, 10, 1, 12, 0, 0, 123000000, time.UTC)
+	time2 = time.Date(2024, 10, 1, 12, 0, 1, 456000000, time.UTC)
+)
+
+func TestAdvance(t *testing.T) {
+	tests := []struct {
+		name string
+		from Position
+		id   string
+		at   time.Time
+		want Position
+	}{
+		{
+			name: "first message",
+			from: Position{},
+			id:   id1,
+			at:   time1,
+			want: Position{ID: id1, CreatedAt: time1, Written: []string{id1}},
+		},
+		{
+			name: "same timestamp is accumulated",
+			from: Position{ID: id1, CreatedAt: time1, Written: []string{id1}},
+			id:   id2,
+			at:   time1,
+			want: Position{ID: id2, CreatedAt: time1, Written: []string{id1, id2}},
+		},
+		{
+			name: "newer timestamp resets written",
+			from: Position{ID: id2, CreatedAt: time1, Written: []string{id1, id2}},
+			id:   id3,
+			at:   time2,
+			want: Position{ID: id3, CreatedAt: time2, Written: []string{id3}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.from.advance(tt.id, tt.at)
+			if !equalPosition(got, tt.want) {
+				t.Errorf("advance(%s, %s)\n got  %+v\n want %+v", tt.id, tt.at, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLastPosition(t *testing.T) {
+	markdown := strings.Builder{}
+	for _, record := range []Record{
+		{ID: id1, Author: "kitsne", Text: "one", CreatedAt: time1},
+		{ID: id2, Author: "kitsne", Text: "two", CreatedAt: time2},
+		{ID: id3, Author: "kitsne", Text: "three", CreatedAt: time2},
+	} {
+		writeMarkdown(&markdown, record)
+	}
+
+	csvText := strings.Join(csvHeader, ",") + "\n" +
+		id1 + ",gps,kitsne,きつね,false,one," + time1.Format(time.RFC3339Nano) + "," + time1.Format(time.RFC3339Nano) + ",\n" +
+		id2 + ",gps,kitsne,きつね,false,\"multi\nline\"," + time2.Format(time.RFC3339Nano) + "," + time2.Format(time.RFC3339Nano) + ",\n" +
+		id3 + ",gps,kitsne,きつね,false,three," + time2.Format(time.RFC3339Nano) + "," + time2.Format(time.RFC3339Nano) + ",\n"
+
+	tests := []struct {
+		name   string
+		format Format
+		input  string
+		want   Position
+	}{
+		{
+			name:   "jsonl",
+			format: JSONL,
+			input: `{"id":"` + id1 + `","createdat":"` + time1.Format(time.RFC3339Nano) + `"}` + "\n" +
+				`{"id":"` + id2 + `","createdat":"` + time2.Format(time.RFC3339Nano) + `"}` + "\n\n",
+			want: Position{ID: id2, CreatedAt: time2, Written: []string{id2}},
+		},
+		{
+			name:   "jsonl with shared timestamp",
+			format: JSONL,
+			input: `{"id":"` + id1 + `","createdat":"` + time1.Format(time.RFC3339Nano) + `"}` + "\n" +
+				`{"id":"` + id2 + `","createdat":"` + time1.Format(time.RFC3339Nano) + `"}` + "\n",
+			want: Position{ID: id2, CreatedAt: time1, Written: []string{id1, id2}},
+		},
+		{
+			name:   "csv with shared timestamp",
+			format: CSV,
+			input:  csvText,
+			want:   Position{ID: id3, CreatedAt: time2, Written: []string{id2, id3}},
+		},
+		{
+			name:   "csv with header only",
+			format: CSV,
+			input:  strings.Join(csvHeader, ",") + "\n",
+			want:   Position{},
+		},
+		{
+			name:   "markdown with shared timestamp",
+			format: Markdown,
+			input:  markdown.String(),
+			want:   Position{ID: id3, CreatedAt: time2, Written: []string{id2, id3}},
+		},
+		{
+			name:   "markdown marker without timestamp",
+			format: Markdown,
+			input:  "### @kitsne\n\none\n\n<!-- id: " + id1 + " -->\n\n---\n\n",
+			want:   Position{ID: id1, Written: []string{id1}},
+		},
+		{
+			name:   "empty file",
+			format: JSONL,
+			input:  "",
+			want:   Position{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LastPosition(strings.NewReader(tt.input), tt.format)
+			if err != nil {
+				t.Fatalf("LastPosition() returned error: %s", err)
+			}
+			if !equalPosition(got, tt.want) {
+				t.Errorf("LastPosition()\n got  %+v\n want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := LastPosition(strings.NewReader(""), Format("xml")); err == nil {
+		t.Errorf("LastPosition() with unknown format returned no error")
+	}
+}
+
+func equalPosition(a Position, b Position) bool {
+	return (a.ID == b.ID) && a.CreatedAt.Equal(b.CreatedAt) && slices.Equal(a.Written, b.Written)
+}
@@ -0,0 +1,134 @@
+package persona
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// traQ にアップロードされたファイルを表す型
+type Attachment struct {
+	Name string `json:"name"` // "image.png"
+	ID   string `json:"id"`   // "0192d23e-2fb1-764b-ba7d-dbabd1185e00"
+	Mime string `json:"mime"` // "image/png"
+	Size int64  `json:"size"` // バイト数
+}
+
+// 引数の UUID をもつファイルの情報を取得
+func GetAttachment(fileID string) *Attachment {
+	resp, _, err := Wsbot.API().FileApi.GetFileMeta(context.Background(), fileID).Execute()
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to get file in GetAttachment(%s)] %s", fileID, err))
+		return nil
+	}
+	return &Attachment{
+		Name: resp.Name,
+		ID:   resp.Id,
+		Mime: resp.Mime,
+		Size: resp.Size,
+	}
+}
+
+// ファイルのリンク。traQ ではメッセージ中のファイルのリンクは添付ファイルとして表示される
+func (at *Attachment) URL() string {
+	if at == nil {
+		return ""
+	}
+	return origin() + "/files/" + at.ID
+}
+
+// ファイルをダウンロードして読み出す。読み終わったら必ず Close する
+func (at *Attachment) Open() (io.ReadCloser, error) {
+	if at == nil {
+		return nil, fmt.Errorf("attachment is nil")
+	}
+	file, _, err := Wsbot.API().FileApi.GetFile(context.Background(), at.ID).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("failed to download file %s: %w", at.ID, err)
+	}
+	// go-traq はダウンロードしたファイルを一時ファイルに書き出して返すので、Close の際に削除する
+	return &tempFile{file}, nil
+}
+
+type tempFile struct {
+	*os.File
+}
+
+func (tf *tempFile) Close() error {
+	err := tf.File.Close()
+	os.Remove(tf.File.Name())
+	return err
+}
+
+// メッセージに添付されたファイルの一覧
+func (ms *Message) Attachments() []*Attachment {
+	attachments := []*Attachment{}
+	if ms == nil {
+		return attachments
+	}
+	_, embeds := Unembed(ms.Text)
+	found := map[string]bool{}
+	for _, e := range embeds {
+		if (e.Type != EmbedFile) || found[e.ID] {
+			continue
+		}
+		found[e.ID] = true
+		if at := GetAttachment(e.ID); at != nil {
+			attachments = append(attachments, at)
+		}
+	}
+	return attachments
+}
+
+// ファイルをアップロードし、説明文とともにチャンネルに投稿する。caption は空でもよい
+func (ch *Channel) SendFile(name string, content io.Reader, caption string) *Message {
+	if ch == nil {
+		return nil
+	}
+	at, err := ch.upload(name, content)
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to upload file \"%s\" on #%s in SendFile()] %s", name, ch.Path, err))
+		return nil
+	}
+
+	ms, err := ch.post(strings.TrimSpace(caption + "\n" + at.URL()))
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to send file \"%s\" on #%s in SendFile()] %s", name, ch.Path, err))
+		return nil
+	}
+	return ms
+}
+
+func (ch *Channel) upload(name string, content io.Reader) (*Attachment, error) {
+	// go-traq はファイル名を *os.File の名前から取るので、同じ名前の一時ファイルに書き出してから渡す
+	dir, err := os.MkdirTemp("", "persona")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	file, err := os.Create(filepath.Join(dir, filepath.Base(name)))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(file, content); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	resp, _, err := Wsbot.API().FileApi.PostFile(context.Background()).File(file).ChannelId(ch.ID).Execute()
+	if err != nil {
+		return nil, err // file は go-traq の中で閉じられる
+	}
+	return &Attachment{Name: resp.Name, ID: resp.Id, Mime: resp.Mime, Size: resp.Size}, nil
+}
@@ -0,0 +1,139 @@
+package persona
+
+import (
+	"path"
+	"regexp"
+	"slices"
+	"sync"
+
+	"github.com/fatih/color"
+)
+
+// メッセージが条件に当てはまるかを判定する関数
+// 条件に当てはまる場合、正規表現のキャプチャグループなどハンドラに渡す文字列を併せて返す
+type Filter func(ms *Message) (bool, []string)
+
+// On で登録されたハンドラ
+type handler struct {
+	filter Filter
+	action func(*Message, []string)
+}
+
+var (
+	handlers   []handler
+	handlersMu sync.RWMutex // イベントは並行して処理されるので登録と参照を保護する
+)
+
+// 条件に当てはまるメッセージを受け取ったときに呼ばれる関数を登録する
+// コマンドとして実行されたメッセージは対象外。当てはまる全てのハンドラが登録順に呼ばれ、
+// どのハンドラにも当てはまらなかった場合にのみ OnMessage が呼ばれる
+func On(filter Filter, action func(ms *Message, groups []string)) {
+	if (filter == nil) || (action == nil) {
+		panic(color.HiRedString("[failed to register handler] filter and action must not be nil"))
+	}
+	handlersMu.Lock()
+	defer handlersMu.Unlock()
+	handlers = append(handlers, handler{filter: filter, action: action})
+}
+
+// 登録されたハンドラのうち条件に当てはまるものを全て実行する。ひとつでも実行されれば true を返す
+func dispatch(ms *Message) bool {
+	handlersMu.RLock()
+	registeredHandlers := slices.Clone(handlers)
+	handlersMu.RUnlock()
+
+	matched := false
+	for _, h := range registeredHandlers {
+		if ok, groups := h.filter(ms); ok {
+			h.action(ms, groups)
+			matched = true
+		}
+	}
+	return matched
+}
+
+// 埋め込みを解消したメッセージテキストが正規表現に一致するか。キャプチャグループの中身をハンドラに渡す
+func Regex(pattern string) Filter {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		panic(color.HiRedString("[failed to compile pattern '%s'] %s", pattern, err))
+	}
+	return func(ms *Message) (bool, []string) {
+		text, _ := Unembed(ms.Text)
+		match := re.FindStringSubmatch(text)
+		if match == nil {
+			return false, nil
+		}
+		return true, match[1:]
+	}
+}
+
+// チャンネルのパスが glob パターンに一致するか。"gps/times/*" のように指定する
+func InChannel(pattern string) Filter {
+	if _, err := path.Match(pattern, ""); err != nil {
+		panic(color.HiRedString("[failed to compile pattern '%s'] %s", pattern, err))
+	}
+	return func(ms *Message) (bool, []string) {
+		if ms.Channel == nil {
+			return false, nil
+		}
+		matched, _ := path.Match(pattern, ms.Channel.Path)
+		return matched, nil
+	}
+}
+
+// 投稿者のユーザー名（traQ ID）が引数のいずれかと一致するか
+func From(names ...string) Filter {
+	return func(ms *Message) (bool, []string) {
+		return (ms.Author != nil) && slices.Contains(names, ms.Author.Name), nil
+	}
+}
+
+// 投稿者が Bot であるか
+func IsBot() Filter {
+	return func(ms *Message) (bool, []string) {
+		return (ms.Author != nil) && ms.Author.IsBot, nil
+	}
+}
+
+// 引数の名前のスタンプがメッセージについているか
+func HasStamp(name string) Filter {
+	return func(ms *Message) (bool, []string) {
+		return slices.ContainsFunc(ms.Stamps, func(st *Stamp) bool { return st.Name == name }), nil
+	}
+}
+
+// 全ての条件に当てはまるか。ハンドラに渡す文字列は各条件のものを順に連結したもの
+func All(filters ...Filter) Filter {
+	return func(ms *Message) (bool, []string) {
+		groups := []string{}
+		for _, filter := range filters {
+			ok, g := filter(ms)
+			if !ok {
+				return false, nil
+			}
+			groups = append(groups, g...)
+		}
+		return true, groups
+	}
+}
+
+// いずれかの条件に当てはまるか。ハンドラに渡す文字列は最初に当てはまった条件のもの
+func Any(filters ...Filter) Filter {
+	return func(ms *Message) (bool, []string) {
+		for _, filter := range filters {
+			if ok, groups := filter(ms); ok {
+				return true, groups
+			}
+		}
+		return false, nil
+	}
+}
+
+// 条件に当てはまらないか
+func Not(filter Filter) Filter {
+	return func(ms *Message) (bool, []string) {
+		ok, _ := filter(ms)
+		return !ok, nil
+	}
+}
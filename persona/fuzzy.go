@@ -0,0 +1,175 @@
+package persona
+
+// 人が手で入力したユーザー名・チャンネル名・スタンプ名から、それらしいものを探すための関数
+// NameGetUser・PathGetChannel・NameGetStamp は完全に一致する名前しか受け付けないので、コマンドの引数を解釈する場合はこちらを使う
+// 大文字と小文字、全角と半角、カタカナとひらがなの違いは無視して比べる
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// 曖昧検索で見つかった候補。Score が大きいほど入力に近い
+type Candidate[T any] struct {
+	Value T
+	Score int
+}
+
+// 一致の度合いごとの点数
+const (
+	scoreExact       = 100 // 完全に一致
+	scorePrefix      = 80  // 前方一致
+	scoreWord        = 60  // "_" や "/" などの区切りの直後から一致
+	scoreContains    = 40  // 部分一致
+	scoreSubsequence = 20  // 入力の文字が順番通りに含まれる
+)
+
+// 入力に近いユーザーを近い順に取得。ユーザー名（traQ ID）と表示名の両方と比べる。先頭の "@" は無視する
+// 候補がなければ空の配列を返す
+func FindUsers(query string) []Candidate[*User] {
+	query = normalizeName(strings.TrimPrefix(strings.TrimSpace(query), "@"))
+	candidates := []Candidate[*User]{}
+	if query == "" {
+		return candidates
+	}
+	for _, user := range getUserDirectory() {
+		score := max(fuzzyScore(query, normalizeName(user.Name)), fuzzyScore(query, normalizeName(user.Nick)))
+		if score > 0 {
+			candidates = append(candidates, Candidate[*User]{Value: user, Score: score})
+		}
+	}
+	return rankCandidates(candidates, func(user *User) string { return user.Name })
+}
+
+// 入力に近いパブリックチャンネルを近い順に取得。パスとチャンネル名の両方と比べる。先頭の "#" は無視する
+// 候補がなければ空の配列を返す
+func FindChannels(query string) []Candidate[*Channel] {
+	query = normalizeName(strings.TrimPrefix(strings.TrimSpace(query), "#"))
+	candidates := []Candidate[*Channel]{}
+	if query == "" {
+		return candidates
+	}
+	getAllChannels().Walk(func(ch *Channel) bool {
+		score := max(fuzzyScore(query, normalizeName(ch.Path)), fuzzyScore(query, normalizeName(ch.Name)))
+		if score > 0 {
+			candidates = append(candidates, Candidate[*Channel]{Value: ch, Score: score})
+		}
+		return true
+	})
+	return rankCandidates(candidates, func(ch *Channel) string { return ch.Path })
+}
+
+// 入力に近いスタンプを近い順に取得。":tada:" のような前後の ":" は無視する
+// 候補がなければ空の配列を返す
+func FindStamps(query string) []Candidate[*Stamp] {
+	query = normalizeName(strings.Trim(strings.TrimSpace(query), ":"))
+	candidates := []Candidate[*Stamp]{}
+	if query == "" {
+		return candidates
+	}
+	for name, stID := range getCachedStamps(false).ID {
+		if score := fuzzyScore(query, normalizeName(name)); score > 0 {
+			candidates = append(candidates, Candidate[*Stamp]{Value: &Stamp{Name: name, ID: stID}, Score: score})
+		}
+	}
+	return rankCandidates(candidates, func(st *Stamp) string { return st.Name })
+}
+
+// 点数の高い順に並べる。同点なら名前の短い順、さらに同じ長さなら辞書順
+func rankCandidates[T any](candidates []Candidate[T], name func(T) string) []Candidate[T] {
+	slices.SortFunc(candidates, func(a, b Candidate[T]) int {
+		return cmp.Or(
+			cmp.Compare(b.Score, a.Score),
+			cmp.Compare(len([]rune(name(a.Value))), len([]rune(name(b.Value)))),
+			strings.Compare(name(a.Value), name(b.Value)),
+		)
+	})
+	return candidates
+}
+
+// 正規化済みの入力と候補の名前を比べて点数をつける。一致しなければ 0
+func fuzzyScore(query string, target string) int {
+	switch {
+	case target == "":
+		return 0
+	case query == target:
+		return scoreExact
+	case strings.HasPrefix(target, query):
+		return scorePrefix
+	}
+
+	index := strings.Index(target, query)
+	for index >= 0 {
+		if before := []rune(target[:index]); (len(before) == 0) || isNameSeparator(before[len(before)-1]) {
+			return scoreWord
+		}
+		next := strings.Index(target[index+1:], query)
+		if next < 0 {
+			break
+		}
+		index += 1 + next
+	}
+	if strings.Contains(target, query) {
+		return scoreContains
+	}
+
+	remaining := []rune(query)
+	for _, r := range target {
+		if r == remaining[0] {
+			remaining = remaining[1:]
+			if len(remaining) == 0 {
+				return scoreSubsequence
+			}
+		}
+	}
+	return 0
+}
+
+func isNameSeparator(r rune) bool {
+	return (r == '_') || (r == '-') || (r == '/') || (r == '.') || unicode.IsSpace(r)
+}
+
+// 半角カタカナ（U+FF66〜U+FF9D）に対応する全角カタカナ
+var halfwidthKana = []rune("ヲァィゥェォャュョッーアイウエオカキクケコサシスセソタチツテトナニヌネノハヒフヘホマミムメモヤユヨラリルレロワン")
+
+// 比較のために名前を正規化する。小文字にし、全角英数字を半角に、半角カタカナとカタカナをひらがなにする
+func normalizeName(name string) string {
+	runes := []rune{}
+	for _, r := range strings.TrimSpace(name) {
+		switch {
+		case (r >= '！') && (r <= '～'): // 全角の英数字・記号
+			r -= '！' - '!'
+		case r == '　':
+			r = ' '
+		case (r >= 'ｦ') && (r <= 'ﾝ'):
+			r = halfwidthKana[r-'ｦ']
+		case (r == 'ﾞ') || (r == '゙') || (r == '゛'): // 濁点は直前の文字と合わせる
+			if len(runes) > 0 {
+				last := runes[len(runes)-1]
+				if strings.ContainsRune("カキクケコサシスセソタチツテトハヒフヘホかきくけこさしすせそたちつてとはひふへほ", last) {
+					runes[len(runes)-1] = last + 1
+				} else if (last == 'ウ') || (last == 'う') {
+					runes[len(runes)-1] = last + ('ヴ' - 'ウ')
+				}
+			}
+			continue
+		case (r == 'ﾟ') || (r == '゚') || (r == '゜'): // 半濁点も同様
+			if len(runes) > 0 {
+				if last := runes[len(runes)-1]; strings.ContainsRune("ハヒフヘホはひふへほ", last) {
+					runes[len(runes)-1] = last + 2
+				}
+			}
+			continue
+		}
+		runes = append(runes, unicode.ToLower(r))
+	}
+
+	for i, r := range runes {
+		if (r >= 'ァ') && (r <= 'ヶ') { // カタカナをひらがなにする。濁点の処理が終わってから行う
+			runes[i] = r - ('ァ' - 'ぁ')
+		}
+	}
+	return string(runes)
+}
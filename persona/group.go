@@ -0,0 +1,130 @@
+package persona
+
+import (
+	"context"
+	"log"
+	"slices"
+
+	"github.com/fatih/color"
+	traq "github.com/traPtitech/go-traq"
+)
+
+// traQ のユーザーグループを表す型
+type Group struct {
+	Name        string `json:"name"`        // "SysAd"
+	ID          string `json:"id"`          // "f2a2c2e2-..."
+	Description string `json:"description"` // グループの説明
+	Type        string `json:"type"`        // "grade" や "" など
+}
+
+// 引数の UUID をもつグループを取得
+func GetGroup(grID string) *Group {
+	resp, _, err := Wsbot.API().GroupApi.GetUserGroup(context.Background(), grID).Execute()
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to get group in GetGroup(%s)] %s", grID, err))
+		return nil
+	}
+	return &Group{
+		Name:        resp.Name,
+		ID:          resp.Id,
+		Description: resp.Description,
+		Type:        resp.Type,
+	}
+}
+
+// 引数の名前をもつグループを取得
+func NameGetGroup(name string) *Group {
+	for _, group := range getAllGroups() {
+		if group.Name == name {
+			return group
+		}
+	}
+	log.Println(color.HiYellowString("[failed to get group in NameGetGroup(\"%s\")] not found such group", name))
+	return nil
+}
+
+// グループのメンバーの配列を取得
+func (gr *Group) Members() []*User {
+	if gr == nil {
+		return []*User{}
+	}
+	resp, _, err := Wsbot.API().GroupApi.GetUserGroupMembers(context.Background(), gr.ID).Execute()
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to get members of %s in Members()] %s", gr.Name, err))
+		return []*User{}
+	}
+	usIDs := []string{}
+	for _, member := range resp {
+		usIDs = append(usIDs, member.Id)
+	}
+	return lookupUsers(usIDs)
+}
+
+// グループの管理者の配列を取得
+func (gr *Group) Admins() []*User {
+	if gr == nil {
+		return []*User{}
+	}
+	resp, _, err := Wsbot.API().GroupApi.GetUserGroupAdmins(context.Background(), gr.ID).Execute()
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to get admins of %s in Admins()] %s", gr.Name, err))
+		return []*User{}
+	}
+	return lookupUsers(resp)
+}
+
+// ユーザーがグループのメンバーであるか
+func (gr *Group) Contains(us *User) bool {
+	if (gr == nil) || (us == nil) {
+		return false
+	}
+	resp, _, err := Wsbot.API().GroupApi.GetUserGroupMembers(context.Background(), gr.ID).Execute()
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to get members of %s in Contains()] %s", gr.Name, err))
+		return false
+	}
+	return slices.ContainsFunc(resp, func(member traq.UserGroupMember) bool { return member.Id == us.ID })
+}
+
+// ユーザーが所属するグループの配列を取得
+func (us *User) Groups() []*Group {
+	if us == nil {
+		return []*Group{}
+	}
+	grIDs := us.groupIDs()
+	if len(grIDs) == 0 {
+		return []*Group{}
+	}
+
+	// グループごとに GetGroup を呼ばず、全てのグループの一覧から探す
+	allGroups := getAllGroups()
+	groups := []*Group{}
+	for _, grID := range grIDs {
+		if group, exists := allGroups[grID]; exists {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// ユーザーが所属するグループの UUID の配列を取得
+func (us *User) groupIDs() []string {
+	resp, _, err := Wsbot.API().UserApi.GetUser(context.Background(), us.ID).Execute()
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to get groups of @%s in groupIDs()] %s", us.Name, err))
+		return []string{}
+	}
+	return resp.Groups
+}
+
+// UUID の配列からユーザーの配列を得る。ユーザーごとに GetUser を呼ばず、全てのユーザーの一覧から探す
+func lookupUsers(usIDs []string) []*User {
+	directory := getUserDirectory()
+	users := []*User{}
+	for _, usID := range usIDs {
+		if user, exists := directory[usID]; exists {
+			users = append(users, user)
+		}
+	}
+	return users
+}
@@ -0,0 +1,171 @@
+package persona
+
+// チャンネルの過去のメッセージを少しずつ読み込むための関数
+// 一度に全てを読み込むとメモリも API の制限も厳しいので、必要になった分だけページ単位で取得する
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"time"
+
+	traq "github.com/traPtitech/go-traq"
+)
+
+// ChannelApi.GetMessages は一度に 200 以上のメッセージを読み込もうとすると 400 Bad Request が返るので 150 刻みに取得する
+const historyPage = 150
+
+// Messages で取得するメッセージの範囲と順番
+type HistoryOptions struct {
+	Since     time.Time // これ以降のメッセージを取得する。ゼロ値なら制限なし
+	Until     time.Time // これ以前のメッセージを取得する。ゼロ値なら制限なし
+	Inclusive bool      // true なら Since・Until ちょうどに投稿されたメッセージも含める
+	Order     string    // "desc"（新しい順）または "asc"（古い順）。空なら "desc"
+}
+
+// チャンネルのメッセージを順に返すイテレータ。ループを抜けるまで必要な分だけ API から取得する
+// for ms, err := range ch.Messages(ctx, prs.HistoryOptions{}) { ... } のように使う
+func (ch *Channel) Messages(ctx context.Context, opts HistoryOptions) iter.Seq2[*Message, error] {
+	return func(yield func(*Message, error) bool) {
+		if ch == nil {
+			yield(nil, fmt.Errorf("channel is nil"))
+			return
+		}
+		ascending := (opts.Order == "asc")
+		if !ascending && (opts.Order != "") && (opts.Order != "desc") {
+			yield(nil, fmt.Errorf("invalid order '%s'", opts.Order))
+			return
+		}
+
+		rs, err := newResolver()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+
+		// オフセットで取得すると読み込み中に投稿されたメッセージの分だけずれるので、
+		// 前のページの最後のメッセージの投稿日時を次のページの境界にする
+		// 同じ日時のメッセージを取りこぼさないよう境界を含めて取得し、既に返したものは読み飛ばす
+		since, until := opts.Since, opts.Until
+		seen := map[string]bool{} // 境界の日時に投稿された、既に返したメッセージ
+
+		for {
+			request := Wsbot.API().ChannelApi.GetMessages(ctx, ch.ID).Limit(historyPage).Inclusive(true)
+			if ascending {
+				request = request.Order("asc")
+			} else {
+				request = request.Order("desc")
+			}
+			if !since.IsZero() {
+				request = request.Since(since)
+			}
+			if !until.IsZero() {
+				request = request.Until(until)
+			}
+
+			resp, _, err := request.Execute()
+			if err != nil {
+				yield(nil, fmt.Errorf("failed to get messages on #%s: %w", ch.Path, err))
+				return
+			}
+
+			fresh := 0
+			for _, message := range resp {
+				if seen[message.Id] || (!opts.Inclusive && onBound(message, opts)) {
+					continue
+				}
+				fresh++
+				if !yield(rs.message(&message, ch), nil) {
+					return
+				}
+			}
+			if (len(resp) < historyPage) || (fresh == 0) {
+				return // 最後のページか、同じ日時のメッセージだけでページが埋まって進めなくなった場合
+			}
+
+			boundary := resp[len(resp)-1].CreatedAt
+			if ascending {
+				since = boundary
+			} else {
+				until = boundary
+			}
+			clear(seen)
+			for _, message := range resp {
+				if message.CreatedAt.Equal(boundary) {
+					seen[message.Id] = true
+				}
+			}
+		}
+	}
+}
+
+// 利用者が指定した範囲のちょうど境界に投稿されたメッセージか
+func onBound(message traq.Message, opts HistoryOptions) bool {
+	return (!opts.Since.IsZero() && message.CreatedAt.Equal(opts.Since)) ||
+		(!opts.Until.IsZero() && message.CreatedAt.Equal(opts.Until))
+}
+
+// API から得たメッセージを Message 型に変換する際に、ユーザーとスタンプの情報を使い回すための型
+// ユーザーは GetUsers の 1 回の呼び出しで得た一覧から、スタンプは使い回しているスタンプの一覧から探すので、
+// ページあたりの API 呼び出しはメッセージ自体の取得の 1 回だけで済む
+type resolver struct {
+	users       map[string]*User  // ユーザーの UUID と User 型との対応
+	stampIDName map[string]string // スタンプの UUID と名前の対応
+	refreshed   bool              // 知らないスタンプに出会ってスタンプの一覧を取得し直したかどうか
+	jst         *time.Location
+}
+
+func newResolver() (*resolver, error) {
+	jst, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load location: %w", err)
+	}
+	return &resolver{
+		users:       getUserDirectory(),
+		stampIDName: getCachedStamps(false).Symbol,
+		jst:         jst,
+	}, nil
+}
+
+// 一覧の取得後に作成されたユーザーなど、一覧にない場合に限り GetUser で取得する
+func (rs *resolver) user(usID string) *User {
+	if user, exists := rs.users[usID]; exists {
+		return user
+	}
+	user := GetUser(usID)
+	rs.users[usID] = user // 取得に失敗した場合も nil を記録して何度も試さないようにする
+	return user
+}
+
+// 使い回している一覧にないスタンプは新しく作成されたものなので、一度だけ一覧を取得し直す
+func (rs *resolver) stampName(stID string) string {
+	name, exists := rs.stampIDName[stID]
+	if !exists && !rs.refreshed {
+		rs.refreshed = true
+		rs.stampIDName = getCachedStamps(true).Symbol
+		name = rs.stampIDName[stID]
+	}
+	return name
+}
+
+func (rs *resolver) message(message *traq.Message, ch *Channel) *Message {
+	stamps := []*Stamp{}
+	for _, mstamp := range message.Stamps {
+		stamps = append(stamps, &Stamp{
+			Name:  rs.stampName(mstamp.StampId),
+			ID:    mstamp.StampId,
+			User:  rs.user(mstamp.UserId),
+			Count: int(mstamp.Count),
+		})
+	}
+
+	return &Message{
+		Channel:   ch,
+		Text:      message.Content,
+		ID:        message.Id,
+		CreatedAt: message.CreatedAt.In(rs.jst),
+		UpdatedAt: message.UpdatedAt.In(rs.jst),
+		Author:    rs.user(message.UserId),
+		Stamps:    stamps,
+	}
+}
@@ -0,0 +1,166 @@
+package persona
+
+// チャンネルの作成・変更とピン留めの関数
+// 取得系の関数と違い、Bot の権限不足などで失敗しやすく呼び出し側で対処が必要になるので、失敗はエラーとして返す
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	traq "github.com/traPtitech/go-traq"
+)
+
+// ピン留めされたメッセージを表す型
+type Pin struct {
+	Message  *Message  `json:"message"`
+	User     *User     `json:"user"` // ピン留めしたユーザー
+	PinnedAt time.Time `json:"pinnedat"`
+}
+
+// 引数の名前の子チャンネルを作成し、作成したチャンネルを返す
+func (ch *Channel) CreateChild(name string) (*Channel, error) {
+	if err := ch.manageable(); err != nil {
+		return nil, err
+	}
+	resp, _, err := Wsbot.API().ChannelApi.CreateChannel(context.Background()).
+		PostChannelRequest(traq.PostChannelRequest{Name: name, Parent: *traq.NewNullableString(&ch.ID)}).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create #%s/%s: %w", ch.Path, name, err)
+	}
+	return &Channel{
+		Name:   resp.Name,
+		Path:   ch.Path + "/" + resp.Name,
+		ID:     resp.Id,
+		Parent: ch,
+	}, nil
+}
+
+// チャンネルのトピックを取得
+func (ch *Channel) Topic() (string, error) {
+	if ch == nil {
+		return "", fmt.Errorf("channel is nil")
+	}
+	resp, _, err := Wsbot.API().ChannelApi.GetChannelTopic(context.Background(), ch.ID).Execute()
+	if err != nil {
+		return "", fmt.Errorf("failed to get topic of #%s: %w", ch.Path, err)
+	}
+	return resp.Topic, nil
+}
+
+// チャンネルのトピックを変更する。空文字列ならトピックを消す
+func (ch *Channel) SetTopic(topic string) error {
+	if ch == nil {
+		return fmt.Errorf("channel is nil")
+	}
+	_, err := Wsbot.API().ChannelApi.EditChannelTopic(context.Background(), ch.ID).
+		PutChannelTopicRequest(traq.PutChannelTopicRequest{Topic: topic}).Execute()
+	if err != nil {
+		return fmt.Errorf("failed to set topic of #%s: %w", ch.Path, err)
+	}
+	return nil
+}
+
+// チャンネルの名前を変更する。成功すれば ch の Name と Path も書き換える
+func (ch *Channel) Rename(name string) error {
+	if err := ch.manageable(); err != nil {
+		return err
+	}
+	if err := ch.patch(traq.PatchChannelRequest{Name: &name}); err != nil {
+		return fmt.Errorf("failed to rename #%s to '%s': %w", ch.Path, name, err)
+	}
+	ch.Name = name
+	if ch.Parent != nil {
+		ch.Path = ch.Parent.Path + "/" + name
+	} else {
+		ch.Path = name
+	}
+	return nil
+}
+
+// チャンネルをアーカイブする
+func (ch *Channel) Archive() error {
+	if err := ch.manageable(); err != nil {
+		return err
+	}
+	archived := true
+	if err := ch.patch(traq.PatchChannelRequest{Archived: &archived}); err != nil {
+		return fmt.Errorf("failed to archive #%s: %w", ch.Path, err)
+	}
+	return nil
+}
+
+// チャンネルのアーカイブを解除する
+func (ch *Channel) Unarchive() error {
+	if err := ch.manageable(); err != nil {
+		return err
+	}
+	archived := false
+	if err := ch.patch(traq.PatchChannelRequest{Archived: &archived}); err != nil {
+		return fmt.Errorf("failed to unarchive #%s: %w", ch.Path, err)
+	}
+	return nil
+}
+
+// チャンネルにピン留めされたメッセージの配列を取得
+func (ch *Channel) Pins() ([]*Pin, error) {
+	if ch == nil {
+		return nil, fmt.Errorf("channel is nil")
+	}
+	resp, _, err := Wsbot.API().ChannelApi.GetChannelPins(context.Background(), ch.ID).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pins on #%s: %w", ch.Path, err)
+	}
+
+	rs, err := newResolver() // ピン留めされたメッセージの投稿者やスタンプも履歴と同じく一覧から探す
+	if err != nil {
+		return nil, err
+	}
+	pins := []*Pin{}
+	for _, pin := range resp {
+		pins = append(pins, &Pin{
+			Message:  rs.message(&pin.Message, ch),
+			User:     rs.user(pin.UserId),
+			PinnedAt: pin.PinnedAt.In(rs.jst),
+		})
+	}
+	return pins, nil
+}
+
+// メッセージをピン留めする
+func (ms *Message) Pin() error {
+	if ms == nil {
+		return fmt.Errorf("message is nil")
+	}
+	if _, _, err := Wsbot.API().MessageApi.CreatePin(context.Background(), ms.ID).Execute(); err != nil {
+		return fmt.Errorf("failed to pin message %s: %w", ms.ID, err)
+	}
+	return nil
+}
+
+// メッセージのピン留めを外す
+func (ms *Message) Unpin() error {
+	if ms == nil {
+		return fmt.Errorf("message is nil")
+	}
+	if _, err := Wsbot.API().MessageApi.RemovePin(context.Background(), ms.ID).Execute(); err != nil {
+		return fmt.Errorf("failed to unpin message %s: %w", ms.ID, err)
+	}
+	return nil
+}
+
+// 作成・名前の変更・アーカイブができるチャンネルか。DM チャンネルはこれらの操作の対象にならない
+func (ch *Channel) manageable() error {
+	if ch == nil {
+		return fmt.Errorf("channel is nil")
+	}
+	if ch.IsDM() {
+		return fmt.Errorf("%s is a DM channel", ch.Path)
+	}
+	return nil
+}
+
+func (ch *Channel) patch(request traq.PatchChannelRequest) error {
+	_, err := Wsbot.API().ChannelApi.EditChannel(context.Background(), ch.ID).PatchChannelRequest(request).Execute()
+	return err
+}
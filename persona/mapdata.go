@@ -3,6 +3,10 @@ package persona
 import (
 	"context"
 	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/fatih/color"
 )
@@ -14,10 +18,15 @@ type bimap struct {
 }
 
 func getAllStamps() bimap {
-	stamps, _, err := Wsbot.API().StampApi.GetStamps(context.Background()).Execute()
+	stamps, err := fetchStamps()
 	if err != nil {
 		log.Println(color.HiYellowString("[failed to get stamps in getAllStamps()] %s", err))
 	}
+	return stamps
+}
+
+func fetchStamps() (bimap, error) {
+	stamps, _, err := Wsbot.API().StampApi.GetStamps(context.Background()).Execute()
 
 	stampNameID := map[string]string{}
 	stampIDName := map[string]string{}
@@ -25,7 +34,37 @@ func getAllStamps() bimap {
 		stampIDName[stamp.Id] = stamp.Name
 		stampNameID[stamp.Name] = stamp.Id
 	}
-	return bimap{stampNameID, stampIDName}
+	return bimap{stampNameID, stampIDName}, err
+}
+
+// スタンプの一覧を使い回す期間
+// スタンプはめったに増えないので、メッセージの履歴を読み込むたびに全てのスタンプを取得し直すのは無駄が大きい
+var StampCacheTTL = 10 * time.Minute
+
+var (
+	stampCache   bimap
+	stampCacheAt time.Time
+	stampCacheMu sync.Mutex
+)
+
+// StampCacheTTL の間はスタンプの一覧を使い回す。refresh が true なら期間内でも取得し直す
+func getCachedStamps(refresh bool) bimap {
+	stampCacheMu.Lock()
+	defer stampCacheMu.Unlock()
+
+	if !refresh && (stampCache.ID != nil) && (time.Since(stampCacheAt) < StampCacheTTL) {
+		return stampCache
+	}
+	stamps, err := fetchStamps()
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to get stamps in getCachedStamps()] %s", err))
+		if stampCache.ID != nil {
+			return stampCache // 取得に失敗した場合は古い一覧で代用する
+		}
+		return stamps
+	}
+	stampCache, stampCacheAt = stamps, time.Now()
+	return stampCache
 }
 
 func getAllUsers() bimap {
@@ -43,44 +82,113 @@ func getAllUsers() bimap {
 	return bimap{userNameID, userIDName}
 }
 
-func getAllChannels() bimap {
+// 全てのユーザーの UUID と User 型との対応。GetUsers の 1 回の呼び出しだけで作る
+func getUserDirectory() map[string]*User {
+	users, _, err := Wsbot.API().UserApi.GetUsers(context.Background()).IncludeSuspended(true).Execute()
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to get users in getUserDirectory()] %s", err))
+	}
+
+	directory := map[string]*User{}
+	for _, user := range users {
+		directory[user.Id] = &User{
+			Nick:  user.DisplayName,
+			Name:  user.Name,
+			ID:    user.Id,
+			IsBot: user.Bot,
+		}
+	}
+	return directory
+}
+
+func getAllChannels() *ChannelTree {
 	// 一度に何百回も API にアクセスするとエラーを生じがちなので
-	// たった一度の API アクセスからチャンネルの path と ID の対応表を作りたい
+	// たった一度の API アクセスからチャンネルの木構造を作りたい
 	// GetChannels によって全てのパブリックチャンネルについて チャンネルのID・親チャンネルのID・チャンネルの名前 の 3 つが分かるので、
 	// 親子の関連付けからチャンネルの親子関係のグラフを作成し、それぞれのチャンネルの名前を末尾まで継承してパスを作る
 
+	tree := &ChannelTree{
+		byID:     map[string]*Channel{},
+		byPath:   map[string]*Channel{},
+		children: map[string][]*Channel{},
+	}
+
 	channels, _, err := Wsbot.API().ChannelApi.GetChannels(context.Background()).IncludeDm(false).Execute()
 	if err != nil {
 		log.Println(color.HiYellowString("[failed to get channels in getAllChannels()] %s", err))
+		return tree
 	}
 
 	idTree := map[string]string{} // 子チャンネルの UUID をキー、親チャンネルの UUID を値にもつ
-	channelIDName := map[string]string{}
-	channelPathID := map[string]string{}
-	channelIDPath := map[string]string{}
 
-	for _, channel := range channels.Public { // resp にはtraQ の全てのスタンプの情報が入っている
-		channelIDName[channel.Id] = channel.Name
+	// channels.Public には traQ の全てのパブリックチャンネルの情報が入っている
+	for _, channel := range channels.Public {
+		tree.byID[channel.Id] = &Channel{Name: channel.Name, ID: channel.Id}
 		parentID := channel.ParentId.Get()
 		if parentID != nil {
 			idTree[channel.Id] = *parentID
 		}
 	}
-	// UUID の木構造と、それぞれの UUID をもつチャンネルの名称を取得。この木からそれぞれのパスを作る
+	// UUID の木構造と、それぞれの UUID をもつチャンネルの名称を取得。この木から親子の関連付けとパスを作る
 
-	for _, channel := range channels.Public {
-		currentID := channel.Id
-		exists := false
-		path := channelIDName[currentID]
-		for {
-			currentID, exists = idTree[currentID]
-			if !exists {
-				break
-			}
-			path = channelIDName[currentID] + "/" + path
+	for chID, ch := range tree.byID {
+		parentID := ""
+		if parent, exists := tree.byID[idTree[chID]]; exists {
+			ch.Parent = parent
+			parentID = parent.ID
+		}
+		tree.children[parentID] = append(tree.children[parentID], ch)
+	}
+	for _, children := range tree.children {
+		slices.SortFunc(children, func(a, b *Channel) int {
+			return strings.Compare(a.Name, b.Name)
+		})
+	}
+
+	for _, ch := range tree.byID {
+		ch.Path = ch.Name
+		for parent := ch.Parent; parent != nil; parent = parent.Parent {
+			ch.Path = parent.Name + "/" + ch.Path
+		}
+		tree.byPath[ch.Path] = ch
+	}
+	return tree
+}
+
+func getDMChannels() bimap {
+	// Bot が持つ DM チャンネルの一覧。ここでは相手のユーザーの UUID を Symbol の代わりとする
+	// ID は ユーザーの UUID → チャンネルの UUID、Symbol は チャンネルの UUID → ユーザーの UUID
+
+	channels, _, err := Wsbot.API().ChannelApi.GetChannels(context.Background()).IncludeDm(true).Execute()
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to get channels in getDMChannels()] %s", err))
+		return bimap{map[string]string{}, map[string]string{}}
+	}
+
+	userChannel := map[string]string{}
+	channelUser := map[string]string{}
+	for _, dm := range channels.Dm {
+		userChannel[dm.UserId] = dm.Id
+		channelUser[dm.Id] = dm.UserId
+	}
+	return bimap{userChannel, channelUser}
+}
+
+func getAllGroups() map[string]*Group {
+	// グループの UUID と Group 型との対応
+	groups, _, err := Wsbot.API().GroupApi.GetUserGroups(context.Background()).Execute()
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to get groups in getAllGroups()] %s", err))
+	}
+
+	directory := map[string]*Group{}
+	for _, group := range groups {
+		directory[group.Id] = &Group{
+			Name:        group.Name,
+			ID:          group.Id,
+			Description: group.Description,
+			Type:        group.Type,
 		}
-		channelPathID[path] = channel.Id
-		channelIDPath[channel.Id] = path
 	}
-	return bimap{channelPathID, channelIDPath}
+	return directory
 }
@@ -0,0 +1,84 @@
+package persona
+
+// スタンプをボタンのように使う選択メニュー
+// Bot が選択肢のスタンプをあらかじめつけておき、ユーザーが同じスタンプを押すと対応する関数を実行する
+
+import (
+	"log"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// SendMenu の挙動の設定
+type MenuConfig struct {
+	Expire time.Duration // メニューを押せる期間。0 なら 10 分
+	Users  []*User       // メニューを押せるユーザー。空なら Bot 以外の全員
+	Once   bool          // true なら最初に押された時点でメニューを締め切る
+}
+
+// 選択肢のスタンプ名と、そのスタンプが押されたときに実行する関数の対応を受け取ってメニューを投稿する
+// 関数にはメニューのメッセージと押したユーザーが渡される。期限が来るとメニューを締め切り、Bot がつけたスタンプを外す
+func (ch *Channel) SendMenu(content string, options map[string]func(*Message, *User) error, config ...MenuConfig) *Message {
+	if ch == nil {
+		return nil
+	}
+	conf := MenuConfig{}
+	if len(config) > 0 {
+		conf = config[0]
+	}
+	if conf.Expire <= 0 {
+		conf.Expire = 10 * time.Minute
+	}
+
+	ms, err := ch.post(content)
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to send menu on #%s in SendMenu()] %s", ch.Path, err))
+		return nil
+	}
+
+	names := []string{}
+	for name := range options {
+		names = append(names, name)
+	}
+	slices.Sort(names) // map の順序は毎回変わるので、スタンプをつける順番を固定する
+
+	pressed := atomic.Bool{} // Once の場合に、既に押されたかどうか
+	closeOnce := sync.Once{}
+	closeMenu := func() {
+		closeOnce.Do(func() {
+			unwatchStamps(ms.ID)
+			ms.Unstamp(names...)
+		})
+	}
+
+	watchStamps(ms.ID, func(menu *Message, st *Stamp, us *User, added bool) {
+		if !added || (us == nil) || us.IsBot {
+			return // Bot 自身が選択肢のスタンプをつけたときもここに来る
+		}
+		if (len(conf.Users) > 0) && !slices.ContainsFunc(conf.Users, func(allowed *User) bool { return allowed.ID == us.ID }) {
+			return
+		}
+		action, exists := options[st.Name]
+		if !exists {
+			return
+		}
+		if conf.Once {
+			// スタンプのイベントは別々の goroutine で届くので、ほぼ同時に押された場合も最初のひとつだけを実行する
+			if !pressed.CompareAndSwap(false, true) {
+				return
+			}
+			closeMenu()
+		}
+		if err := action(menu, us); err != nil {
+			log.Println(color.HiYellowString("[failed to run menu option '%s' on #%s] %s", st.Name, ch.Path, err))
+		}
+	})
+
+	ms.Stamp(names...)
+	time.AfterFunc(conf.Expire, closeMenu)
+	return ms
+}
@@ -2,9 +2,7 @@ package persona
 
 import (
 	"context"
-	"encoding/json"
 	"log"
-	"slices"
 	"time"
 
 	"github.com/fatih/color"
@@ -112,54 +110,55 @@ func (ms *Message) Edit(content string) {
 	}
 }
 
-// メッセージ中の埋め込みを表す型
-type Embed struct {
-	Type  string `json:"type"`  // "user"
-	Raw   string `json:"raw"`   // "@BOT_nek"
-	ID    string `json:"id"`    // "0192d23e-2fb1-764b-ba7d-dbabd1185e00"
-	Start int    `json:"start"` // 埋め込みの開始位置
-	End   int    `json:"end"`   // 埋め込みの終了位置
+// traQ の Web クライアントのオリジン。メッセージやファイルのリンクはこの下にある
+func origin() string {
+	if Wsbot == nil {
+		return "https://q.trap.jp"
+	}
+	conf := Wsbot.API().GetConfig()
+	if conf.Host == "" {
+		return "https://q.trap.jp" // traq-ws-bot の既定値
+	}
+	return conf.Scheme + "://" + conf.Host
 }
 
-// traQ 内部で使われている Unembedder と概ね同じロジック（TypeScript で書かれている）を Go で再実装
-// traq-ws-bot でメッセージイベントを受け取った時には PlainText を取得できるが、go-traq の API としては提供されていない
-// https://github.com/traPtitech/traQ_S-UI/blob/master/src/lib/markdown/internalLinkUnembedder.ts
-// 基本的に埋め込みは type, raw, id の 3 つのキーのみから構成される JSON 文字列 !{ ... } である
-
-// メッセージ本文を埋め込みのないもとの Markdown の形式に変換する
-func Unembed(text string) (string, []Embed) {
-	textRune := []rune(text)
-	inEmbed := false
-
-	embeds := []Embed{}
-	data := Embed{}
-
-	for i := 0; i < len(textRune); i++ {
-		if inEmbed {
-			if textRune[i] == '}' {
-				inEmbed = false
-				data.End = i + 1
-				err := json.Unmarshal([]byte(string(textRune[data.Start+1:i+1])), &data)
-				if err == nil {
-					embeds = append(embeds, data)
-				}
-			}
-		} else {
-			if (i < len(textRune)-1) && textRune[i] == '!' && textRune[i+1] == '{' {
-				inEmbed = true
-				data = Embed{Start: i}
-			}
-		}
+// メッセージリンク。traQ ではメッセージ中のメッセージリンクは引用として表示される
+func (ms *Message) URL() string {
+	if ms == nil {
+		return ""
 	}
+	return origin() + "/messages/" + ms.ID
+}
 
-	slices.Reverse(embeds)
-	// 得られた embed を後ろから順に置き換えて埋め込みを解消する
-
-	for _, data := range embeds {
-		tempRune := append([]rune(data.Raw), textRune[data.End:]...)
-		textRune = append(textRune[:data.Start], tempRune...)
+// メッセージと同じチャンネルに、元のメッセージを引用した返信を投稿する
+// mention が true なら本文の先頭で元のメッセージの投稿者にメンションする
+// Respond と同様に、コマンドの再実行時には前回の返信を編集する
+func (ms *Message) Reply(content string, mention bool) *Message {
+	if ms == nil {
+		return nil
+	}
+	if mention && (ms.Author != nil) {
+		content = Mention(ms.Author) + " " + content
 	}
+	return ms.Respond(content + "\n" + ms.URL())
+}
 
-	slices.Reverse(embeds)
-	return string(textRune), embeds
+// メッセージ中のメッセージリンクが指すメッセージを取得。同じメッセージは一度だけ含める
+func (ms *Message) Quotes() []*Message {
+	quotes := []*Message{}
+	if ms == nil {
+		return quotes
+	}
+	_, embeds := Unembed(ms.Text)
+	found := map[string]bool{}
+	for _, e := range embeds {
+		if (e.Type != EmbedMessage) || found[e.ID] {
+			continue
+		}
+		found[e.ID] = true
+		if quote := e.Message(); quote != nil {
+			quotes = append(quotes, quote)
+		}
+	}
+	return quotes
 }
@@ -0,0 +1,79 @@
+package persona
+
+// 矢印のスタンプでめくれる複数ページの投稿
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// SendPages で最後に操作されてからページ送りを受け付ける期間
+var PageTimeout = 5 * time.Minute
+
+const (
+	pagePrev = "arrow_left"
+	pageNext = "arrow_right"
+)
+
+// 1 ページ目を投稿し、矢印のスタンプでページをめくれるようにする
+// traQ では同じスタンプを重ねて押せないので、矢印のスタンプは押しても外してもページがめくれる
+// PageTimeout の間操作がなければページ送りを締め切り、Bot がつけた矢印のスタンプを外す
+func (ch *Channel) SendPages(pages []string) *Message {
+	if ch == nil {
+		return nil
+	}
+	if len(pages) == 0 {
+		log.Println(color.HiYellowString("[failed to send pages on #%s in SendPages()] no page", ch.Path))
+		return nil
+	}
+
+	render := func(i int) string {
+		return fmt.Sprintf("%s\n(%d/%d)", pages[i], i+1, len(pages))
+	}
+	if len(pages) == 1 {
+		ms, err := ch.post(pages[0])
+		if err != nil {
+			log.Println(color.HiYellowString("[failed to send pages on #%s in SendPages()] %s", ch.Path, err))
+		}
+		return ms
+	}
+
+	ms, err := ch.post(render(0))
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to send pages on #%s in SendPages()] %s", ch.Path, err))
+		return nil
+	}
+
+	current := 0
+	mu := sync.Mutex{}
+	timer := time.AfterFunc(PageTimeout, func() {
+		unwatchStamps(ms.ID)
+		ms.Unstamp(pagePrev, pageNext)
+	})
+
+	watchStamps(ms.ID, func(_ *Message, st *Stamp, us *User, _ bool) {
+		if (us == nil) || us.IsBot {
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		switch st.Name {
+		case pagePrev:
+			current = (current + len(pages) - 1) % len(pages)
+		case pageNext:
+			current = (current + 1) % len(pages)
+		default:
+			return
+		}
+		ms.Edit(render(current))
+		timer.Reset(PageTimeout) // 操作されるたびに締切を延ばす
+	})
+
+	ms.Stamp(pagePrev, pageNext)
+	return ms
+}
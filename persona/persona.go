@@ -25,13 +25,13 @@ type Command struct {
 	action func(*Message, ...any) error // Action を可変引数化した関数。実際に実行されるのはこっち
 }
 
-// コマンド以外で新規メッセージを受け取ったときに呼ばれる関数
+// コマンド以外で新規メッセージを受け取ったときに呼ばれる関数。On で登録したハンドラが実行された場合は呼ばれない
 var OnMessage func(*Message)
 
 // コマンドの実行に失敗したときに呼ばれる関数
 var OnFail func(*Message, *Command, error)
 
-// 投稿のメッセージにスタンプが追加・削除されたときに呼ばれる関数
+// Bot の投稿にスタンプが追加・削除されたときに呼ばれる関数。どのスタンプかは OnStampAdded・OnStampRemoved で分かる
 var OnStampUpdate func(*Message)
 
 // WebSocket Bot 本体
@@ -40,6 +40,9 @@ var Wsbot *traqwsbot.Bot
 // コマンドの名前と実行する関数の対応
 type Commands map[string]*Command
 
+// SetUp で登録されたコマンドセット
+var registered Commands
+
 func init() {
 	godotenv.Load(".env")
 }
@@ -69,62 +72,117 @@ func SetUp(commands Commands) {
 		panic(color.HiRedString("[failed to create a new bot] %s", err))
 	}
 
-	Wsbot.OnMessageCreated(func(p *payload.MessageCreated) {
-		ms := GetMessage(p.Message.ID)
-		if ms == nil {
-			return
-		}
+	registered = commands
 
-		// 送られてきたメッセージがコマンドであるならば適切に解釈してコマンドを実行する
-		_, embeds := Unembed(ms.Text)
-
-		if (len(embeds) > 0) && (embeds[0].Start == 0) {
-			if (embeds[0].Type == "user") && (embeds[0].ID == GetMe().ID) {
-				// メッセージの最初で Bot 自身に対するメンションがなされている場合
-
-				elements := strings.SplitN(strings.TrimSpace(ms.Text[embeds[0].End:]), " ", 2)
-				// "@BOT_name" 以降のメッセージテキストで最初の半角スペースを見つけて最大 2 つに切り分ける
-				elements = append(elements, make([]string, 2-len(elements))...) // 常に elements の長さを 2 にする
-				command, exists := commands[elements[0]]
-				if exists {
-					// "@BOT_name コマンド" または "@BOT_name コマンド 引数" の形式のみコマンドとして認識
-					if err = command.parseExecute(ms, elements[1]); err != nil {
-						if OnFail != nil {
-							OnFail(ms, command, err)
-						} else {
-							log.Println(color.HiYellowString("[failed to run command '%s'] %s", elements[0], err))
-						}
-					}
-					return
-				}
-			}
+	Wsbot.OnMessageCreated(func(p *payload.MessageCreated) {
+		if ms := GetMessage(p.Message.ID); ms != nil {
+			receive(ms)
 		}
+	})
 
-		// コマンドの実行条件に当てはまらなかった場合、通常メッセージとして扱い onMessage を実行する
-		if OnMessage != nil {
-			OnMessage(ms)
+	Wsbot.OnDirectMessageCreated(func(p *payload.DirectMessageCreated) {
+		if ms := GetMessage(p.Message.ID); ms != nil {
+			receive(ms)
 		}
 	})
 
 	Wsbot.OnBotMessageStampsUpdated(func(p *payload.BotMessageStampsUpdated) {
+		// どのスタンプが変更されたかの情報までは提供されていないので、前回のスナップショットと照合して求める
+		added, removed := updateSnapshot(p)
+
 		ms := GetMessage(p.MessageID)
 		if ms == nil {
 			return
 		}
 
-		// どのスタンプが変更されたかの情報までは提供されていない
-		// 必要があれば逐一データベースに保存して変更前と照合することで情報を得ることはできる
-		OnStampUpdate(ms)
+		if OnStampUpdate != nil {
+			OnStampUpdate(ms)
+		}
+		emitStampDiff(ms, added, removed)
 	})
 
+	subscribeEvents()
+
 	log.Println(color.GreenString("[initialized bot]"))
 }
 
+// 新規メッセージを受け取ったときの処理。チャンネルへの投稿と DM の両方がここを通る
+func receive(ms *Message) {
+	// WaitReply などで次のメッセージを待っている呼び出しがあれば、まずそちらに渡す
+	if deliver(ms) {
+		return
+	}
+
+	// 投稿者と進行中の対話があれば、その対話の現在の状態で処理する
+	if continueDialog(ms) {
+		return
+	}
+
+	// 送られてきたメッセージがコマンドであるならば適切に解釈してコマンドを実行する
+	command, option, called := findCommand(ms)
+	if called && RerunOnEdit {
+		trackInvocation(ms.ID) // 存在しないコマンドの呼び出しも、編集で正しく直される可能性があるので記録する
+	}
+	if command != nil {
+		runCommand(ms, command, option)
+		return
+	}
+
+	// コマンドの実行条件に当てはまらなかった場合、通常メッセージとして扱い On で登録されたハンドラを実行する
+	if dispatch(ms) {
+		return
+	}
+
+	// どのハンドラにも当てはまらなかった場合、onMessage を実行する
+	if OnMessage != nil {
+		OnMessage(ms)
+	}
+}
+
+// コマンドを実行し、失敗すれば OnFail を呼ぶ
+func runCommand(ms *Message, command *Command, option string) {
+	if err := command.parseExecute(ms, option); err != nil {
+		if OnFail != nil {
+			OnFail(ms, command, err)
+		} else {
+			log.Println(color.HiYellowString("[failed to run command '%s'] %s", command.Name, err))
+		}
+	}
+}
+
+// メッセージが Bot に対するコマンドの呼び出しであれば、そのコマンドと引数部分の文字列を返す
+// 3 つ目の返り値は Bot に宛てたメッセージであるかどうかで、存在しないコマンドを呼び出した場合は command が nil になる
+func findCommand(ms *Message) (*Command, string, bool) {
+	text := ms.Text
+	_, embeds := Unembed(ms.Text)
+
+	me := getMe()
+	if (me != nil) && (len(embeds) > 0) && (embeds[0].Start == 0) && (embeds[0].Type == EmbedUser) && (embeds[0].ID == me.ID) {
+		// メッセージの最初で Bot 自身に対するメンションがなされている場合
+		text = string([]rune(ms.Text)[embeds[0].End:]) // Start と End は rune 単位
+	} else if !ms.Channel.IsDM() {
+		// DM ではメンションを省略してコマンドを呼び出せる
+		return nil, "", false
+	}
+
+	elements := strings.SplitN(strings.TrimSpace(text), " ", 2)
+	// "@BOT_name" 以降のメッセージテキストで最初の半角スペースを見つけて最大 2 つに切り分ける
+	elements = append(elements, make([]string, 2-len(elements))...) // 常に elements の長さを 2 にする
+	command, exists := registered[elements[0]]
+	// "@BOT_name コマンド" または "@BOT_name コマンド 引数" の形式のみコマンドとして認識
+	// DM では "コマンド" または "コマンド 引数" の形式も認める
+	if !exists {
+		return nil, "", true
+	}
+	return command, elements[1], true
+}
+
 // Bot を起動。Bot が停止するとエラーを表示し panic する
 func Start() error {
 	if Wsbot == nil {
 		panic(color.HiRedString("[bot is not set up]"))
 	}
+	RestorePolls() // capsule に保存された締切前の投票があれば締切を予約し直す
 	err := Wsbot.Start()
 	panic(color.HiRedString("[bot shut down] %s", err))
 }
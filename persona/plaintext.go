@@ -0,0 +1,246 @@
+package persona
+
+// メッセージテキストから Markdown の記法や埋め込み、スタンプを取り除いた平文を得るための関数
+// 単語の出現数を数えたりマルコフ連鎖で文章を作ったりと、文章そのものを解析する用途を想定している
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// PlainText の変換の設定
+type PlainOptions struct {
+	KeepMentions bool // true ならユーザー・グループへのメンションとチャンネルリンクを "@kitsne" "#gps/times" の形で残す
+	KeepURLs     bool // true なら URL を本文中に残す。false なら取り除く。いずれの場合も URL の一覧は別に返す
+}
+
+var (
+	plainImage    = regexp.MustCompile(`!\[([^\]]*)\]\(\s*(\S+?)(?:\s+"[^"]*")?\s*\)`)
+	plainLink     = regexp.MustCompile(`\[([^\]]*)\]\(\s*(\S+?)(?:\s+"[^"]*")?\s*\)`)
+	plainAutoLink = regexp.MustCompile(`<(https?://[^>\s]+)>`)
+	plainURL      = regexp.MustCompile(`https?://[^\s<>()\[\]]+[^\s<>()\[\].,;:!?'"]`)
+	plainSpoiler  = regexp.MustCompile(`!!(.+?)!!`)
+	plainEscape   = regexp.MustCompile("\\\\([!-/:-@\\[-`{-~])")
+
+	plainHeading = regexp.MustCompile(`^\s{0,3}#{1,6}(\s+|$)`)
+	plainQuote   = regexp.MustCompile(`^\s{0,3}(>\s?)+`)
+	plainList    = regexp.MustCompile(`^\s*([-*+]|\d+[.)])\s+(\[[ xX]\]\s+)?`)
+	plainRule    = regexp.MustCompile(`^\s{0,3}([-*_])(\s*[-*_]){2,}\s*$`)
+	plainTable   = regexp.MustCompile(`^\s*\|?\s*:?-+:?\s*(\|\s*:?-+:?\s*)*\|?\s*$`)
+	plainSpaces  = regexp.MustCompile(`[ \t\x{3000}]+`)
+)
+
+// 強調の記法。長いものから順に取り除く
+var plainEmphases = []string{"**", "__", "~~", "*", "_"}
+
+// スタンプ名と UUID の対応を得る関数。実在するスタンプの記法だけを取り除くために使う
+var plainStampNames = func() map[string]string {
+	return getCachedStamps(false).ID
+}
+
+// メッセージテキストを平文に変換し、含まれていた URL の一覧と併せて返す
+func (ms *Message) PlainText(opts PlainOptions) (string, []string) {
+	if ms == nil {
+		return "", []string{}
+	}
+	return PlainText(ms.Text, opts)
+}
+
+// 埋め込みを含むメッセージテキストを平文に変換し、含まれていた URL の一覧と併せて返す
+// コードブロックとスタンプは取り除き、インラインコード・スポイラー・リンクなどは中身の文字列だけを残す
+func PlainText(text string, opts PlainOptions) (string, []string) {
+	textRune := []rune(text)
+	_, embeds := Unembed(text)
+
+	// スタンプの記法は実在するスタンプのものだけを取り除く。一覧はスタンプの記法がある場合に限り取得する
+	stampNameID := map[string]string{}
+	if slices.ContainsFunc(embeds, func(e Embed) bool { return e.Type == EmbedStamp }) {
+		stampNameID = plainStampNames()
+	}
+
+	// 埋め込みを後ろから順に置き換える。メッセージ・ファイルのリンクは URL としてこの後で扱う
+	for i := len(embeds) - 1; i >= 0; i-- {
+		data := embeds[i]
+		replacement := ""
+		switch data.Type {
+		case EmbedUser, EmbedGroup, EmbedChannel:
+			if opts.KeepMentions {
+				replacement = data.Raw
+			}
+		case EmbedStamp:
+			name, _, _ := strings.Cut(strings.Trim(data.Raw, ":"), ".") // :tada.ex-large: のようなエフェクトを取り除く
+			if _, exists := stampNameID[name]; !exists {
+				continue
+			}
+		default:
+			continue
+		}
+		tempRune := append([]rune(replacement), textRune[data.End:]...)
+		textRune = append(textRune[:data.Start], tempRune...)
+	}
+
+	urls := []string{}
+	lines := []string{}
+	fence := []rune{}
+
+	for _, line := range strings.Split(string(textRune), "\n") {
+		lineRune := []rune(line)
+		if marker := fenceMarker(lineRune, 0); len(fence) > 0 {
+			if (len(marker) >= len(fence)) && (marker[0] == fence[0]) && isBlank(lineRune, marker) {
+				fence = []rune{}
+			}
+			continue // コードブロックの中身は取り除く
+		} else if len(marker) >= 3 {
+			fence = marker
+			continue
+		}
+
+		if plainRule.MatchString(line) || plainTable.MatchString(line) {
+			continue
+		}
+		line = plainHeading.ReplaceAllString(line, "")
+		line = plainQuote.ReplaceAllString(line, "")
+		line = plainList.ReplaceAllString(line, "")
+		if strings.HasPrefix(strings.TrimSpace(line), "|") {
+			line = strings.ReplaceAll(line, "|", " ") // 表の行は区切りを空白にする
+		}
+
+		line = plainInline(line, opts, &urls)
+		line = strings.TrimSpace(plainSpaces.ReplaceAllString(line, " "))
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "\n"), urls
+}
+
+// 1 行の中のインラインの記法を変換する。インラインコードの中身には手を加えない
+func plainInline(line string, opts PlainOptions, urls *[]string) string {
+	lineRune := []rune(line)
+	result := strings.Builder{}
+
+	for start := 0; start < len(lineRune); {
+		codeStart := start
+		for (codeStart < len(lineRune)) && (lineRune[codeStart] != '`') {
+			if lineRune[codeStart] == '\\' {
+				codeStart++
+			}
+			codeStart++
+		}
+		codeStart = min(codeStart, len(lineRune))
+		result.WriteString(plainMarkup(string(lineRune[start:codeStart]), opts, urls))
+		if codeStart == len(lineRune) {
+			break
+		}
+
+		codeEnd := skipCodeSpan(lineRune, codeStart)
+		ticks := 0
+		for (codeStart+ticks < codeEnd) && (lineRune[codeStart+ticks] == '`') {
+			ticks++
+		}
+		if codeEnd-codeStart > 2*ticks {
+			result.WriteString(strings.TrimSpace(string(lineRune[codeStart+ticks : codeEnd-ticks])))
+		}
+		start = codeEnd
+	}
+	return result.String()
+}
+
+// インラインコード以外の部分の記法を変換する
+func plainMarkup(text string, opts PlainOptions, urls *[]string) string {
+	text = protectEscapes(text)
+
+	keepURL := func(url string) string {
+		*urls = append(*urls, url)
+		if opts.KeepURLs {
+			return url
+		}
+		return ""
+	}
+
+	text = plainImage.ReplaceAllStringFunc(text, func(match string) string {
+		groups := plainImage.FindStringSubmatch(match)
+		return strings.TrimSpace(groups[1] + " " + keepURL(groups[2]))
+	})
+	text = plainLink.ReplaceAllStringFunc(text, func(match string) string {
+		groups := plainLink.FindStringSubmatch(match)
+		return strings.TrimSpace(groups[1] + " " + keepURL(groups[2]))
+	})
+	text = plainAutoLink.ReplaceAllStringFunc(text, func(match string) string {
+		return keepURL(plainAutoLink.FindStringSubmatch(match)[1])
+	})
+
+	// リンクとして処理済みの URL を二重に数えないよう、未処理の部分だけから裸の URL を探す
+	if !opts.KeepURLs {
+		text = plainURL.ReplaceAllStringFunc(text, keepURL)
+	} else {
+		counted := len(*urls)
+		for _, url := range plainURL.FindAllString(text, -1) {
+			if !slices.Contains((*urls)[:counted], url) {
+				*urls = append(*urls, url)
+			}
+		}
+	}
+
+	text = plainSpoiler.ReplaceAllString(text, "$1")
+	for _, delim := range plainEmphases {
+		text = stripEmphasis(text, delim)
+	}
+	return restoreEscapes(text)
+}
+
+// 対になった強調の記法 *text* などの記号だけを取り除く。"2*3*4" のような対になっていない記号は残す
+// 開く記号は直後が空白でなく直前が英数字でないもの、閉じる記号は直前が空白でなく直後が英数字でないものとする
+func stripEmphasis(text string, delim string) string {
+	textRune, delimRune := []rune(text), []rune(delim)
+	n := len(delimRune)
+	isAlnum := func(r rune) bool {
+		return ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9')
+	}
+	opens := func(i int) bool {
+		return (i+n < len(textRune)) && !unicode.IsSpace(textRune[i+n]) && ((i == 0) || !isAlnum(textRune[i-1]))
+	}
+	closes := func(j int) bool {
+		return !unicode.IsSpace(textRune[j-1]) && ((j+n >= len(textRune)) || !isAlnum(textRune[j+n]))
+	}
+
+	result := []rune{}
+	for i := 0; i < len(textRune); {
+		if hasPrefixRune(textRune[i:], delimRune) && opens(i) {
+			closing := -1
+			for j := i + n + 1; j+n <= len(textRune); j++ {
+				if hasPrefixRune(textRune[j:], delimRune) && closes(j) {
+					closing = j
+					break
+				}
+			}
+			if closing != -1 {
+				result = append(result, textRune[i+n:closing]...)
+				i = closing + n
+				continue
+			}
+		}
+		result = append(result, textRune[i])
+		i++
+	}
+	return string(result)
+}
+
+// バックスラッシュでエスケープされた記号を私用領域の文字に置き換え、以降の変換で記法として扱われないようにする
+func protectEscapes(text string) string {
+	return plainEscape.ReplaceAllStringFunc(text, func(match string) string {
+		return string(rune(0xE000 + int(match[1])))
+	})
+}
+
+func restoreEscapes(text string) string {
+	return strings.Map(func(r rune) rune {
+		if (0xE000 <= r) && (r < 0xE080) {
+			return r - 0xE000
+		}
+		return r
+	}, text)
+}
@@ -0,0 +1,110 @@
+package persona
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPlainText(t *testing.T) {
+	original := plainStampNames
+	plainStampNames = func() map[string]string {
+		return map[string]string{"tada": "stamp-tada", "done-nya": "stamp-done-nya"}
+	}
+	defer func() { plainStampNames = original }()
+
+	mention := `!{"type":"user","raw":"@kitsne","id":"a77f54f2-a7dc-4dab-ad6d-5c5df7e9ecfa"}`
+
+	tests := []struct {
+		name  string
+		text  string
+		opts  PlainOptions
+		plain string
+		urls  []string
+	}{
+		{
+			name:  "time is kept",
+			text:  "at 12:30:45",
+			plain: "at 12:30:45",
+			urls:  []string{},
+		},
+		{
+			name:  "known stamps are removed",
+			text:  "nice :tada: :done-nya.ex-large: work",
+			plain: "nice work",
+			urls:  []string{},
+		},
+		{
+			name:  "unknown stamp notation is kept",
+			text:  "ratio a:b:c and :unknown:",
+			plain: "ratio a:b:c and :unknown:",
+			urls:  []string{},
+		},
+		{
+			name:  "lone asterisks are kept",
+			text:  "2*3*4 and a * b",
+			plain: "2*3*4 and a * b",
+			urls:  []string{},
+		},
+		{
+			name:  "paired emphasis is removed",
+			text:  "**bold** *italic* ~~strike~~ _under_ これは**強調**です",
+			plain: "bold italic strike under これは強調です",
+			urls:  []string{},
+		},
+		{
+			name:  "snake case is kept",
+			text:  "use snake_case_name here",
+			plain: "use snake_case_name here",
+			urls:  []string{},
+		},
+		{
+			name:  "escaped asterisks are kept",
+			text:  `\*not italic\*`,
+			plain: "*not italic*",
+			urls:  []string{},
+		},
+		{
+			name:  "mentions are removed",
+			text:  mention + " hello",
+			plain: "hello",
+			urls:  []string{},
+		},
+		{
+			name:  "mentions are kept",
+			text:  mention + " hello",
+			opts:  PlainOptions{KeepMentions: true},
+			plain: "@kitsne hello",
+			urls:  []string{},
+		},
+		{
+			name:  "code block and inline code",
+			text:  "before\n```go\nfmt.Println()\n```\nrun `go test` now",
+			plain: "before\nrun go test now",
+			urls:  []string{},
+		},
+		{
+			name:  "links and urls",
+			text:  "see [docs](https://example.com/docs) or https://example.com/raw.",
+			plain: "see docs or .",
+			urls:  []string{"https://example.com/docs", "https://example.com/raw"},
+		},
+		{
+			name:  "spoiler and heading",
+			text:  "# title\n!!secret!!",
+			plain: "title\nsecret",
+			urls:  []string{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, urls := PlainText(tt.text, tt.opts)
+			if plain != tt.plain {
+				t.Errorf("PlainText(%q) text\n got  %q\n want %q", tt.text, plain, tt.plain)
+			}
+			if !slices.Equal(urls, tt.urls) {
+				t.Errorf("PlainText(%q) urls\n got  %q\n want %q", tt.text, urls, tt.urls)
+			}
+		})
+	}
+}
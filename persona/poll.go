@@ -0,0 +1,227 @@
+package persona
+
+// スタンプによる投票
+// 選択肢ごとに番号のスタンプをつけた投稿を作り、締切の時点でついているスタンプを集計して結果を投稿する
+// 投票の情報は capsule に保存されるので、締切前に Bot を再起動しても Start の時点で締切の予約をやり直す
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	cps "github.com/kitsne241/go-qourier/capsule"
+)
+
+// 選択肢につける番号のスタンプ。選択肢は最大 10 個
+var pollStamps = []string{"one", "two", "three", "four", "five", "six", "seven", "eight", "nine", "keycap_ten"}
+
+// 投票を表す型
+type Poll struct {
+	MessageID string    `json:"messageid"` // 投票の投稿の UUID
+	ChannelID string    `json:"channelid"`
+	Question  string    `json:"question"`
+	Choices   []string  `json:"choices"`
+	Multiple  bool      `json:"multiple"` // true なら複数の選択肢に投票できる
+	Deadline  time.Time `json:"deadline"`
+}
+
+// 投票の集計結果
+type PollResult struct {
+	Poll    *Poll
+	Votes   [][]*User // 選択肢ごとの投票者。Votes[i] は Choices[i] に投票したユーザー
+	Invalid []*User   // 複数選択できない投票で複数の選択肢に投票したユーザー。どの選択肢にも数えない
+}
+
+var (
+	pollTimers   = map[string]*time.Timer{} // 締切を待っている投票。投稿の UUID をキーとする
+	pollTimersMu sync.Mutex
+)
+
+// 締め切りに失敗した投票を締め切り直すまでの時間
+const pollRetryDelay = time.Minute
+
+// 投票を投稿する。締切になると集計して結果をチャンネルに投稿する
+func (ch *Channel) SendPoll(question string, choices []string, deadline time.Time, multiple bool) *Poll {
+	if ch == nil {
+		return nil
+	}
+	if (len(choices) == 0) || (len(choices) > len(pollStamps)) {
+		log.Println(color.HiYellowString(
+			"[failed to send poll on #%s in SendPoll()] number of choices must be 1 to %d", ch.Path, len(pollStamps),
+		))
+		return nil
+	}
+
+	pl := &Poll{
+		ChannelID: ch.ID,
+		Question:  question,
+		Choices:   choices,
+		Multiple:  multiple,
+		Deadline:  deadline,
+	}
+
+	ms, err := ch.post(pl.content())
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to send poll on #%s in SendPoll()] %s", ch.Path, err))
+		return nil
+	}
+	pl.MessageID = ms.ID
+	ms.Stamp(pollStamps[:len(choices)]...)
+
+	if cps.Db != nil {
+		if err := cps.SaveRecord(pollKey(pl.MessageID), pl); err != nil {
+			log.Println(color.HiYellowString("[failed to save poll %s in SendPoll()] %s", pl.MessageID, err))
+		}
+	}
+	pl.schedule()
+	return pl
+}
+
+// capsule に保存された締切前の投票について締切を予約し直す。Start の中で自動的に呼ばれる
+func RestorePolls() {
+	if cps.Db == nil {
+		return
+	}
+	names, err := cps.RecordNames("poll/")
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to restore polls in RestorePolls()] %s", err))
+		return
+	}
+	for _, name := range names {
+		pl, exists, err := cps.LoadRecord[*Poll](name)
+		if err != nil {
+			log.Println(color.HiYellowString("[failed to restore poll %s in RestorePolls()] %s", name, err))
+			continue
+		}
+		if exists {
+			pl.schedule() // 停止中に締切を過ぎていればすぐに締め切られる
+		}
+	}
+}
+
+// 現在ついているスタンプから投票を集計する
+func (pl *Poll) Tally() *PollResult {
+	ms := GetMessage(pl.MessageID)
+	if ms == nil {
+		return nil
+	}
+
+	result := &PollResult{Poll: pl, Votes: make([][]*User, len(pl.Choices)), Invalid: []*User{}}
+	chosen := map[string][]int{} // ユーザーの UUID と、そのユーザーが投票した選択肢の番号
+	users := map[string]*User{}
+
+	for _, st := range ms.Stamps {
+		i := slices.Index(pollStamps[:len(pl.Choices)], st.Name)
+		if (i == -1) || (st.User == nil) || st.User.IsBot {
+			continue // Bot 自身があらかじめつけたスタンプは数えない
+		}
+		chosen[st.User.ID] = append(chosen[st.User.ID], i)
+		users[st.User.ID] = st.User
+	}
+
+	for usID, indices := range chosen {
+		if !pl.Multiple && (len(indices) > 1) {
+			result.Invalid = append(result.Invalid, users[usID])
+			continue
+		}
+		for _, i := range indices {
+			result.Votes[i] = append(result.Votes[i], users[usID])
+		}
+	}
+	return result
+}
+
+// 締切を待たずに投票を締め切り、結果を投稿する。締め切り済みの投票に対しては何もしない
+func (pl *Poll) Close() {
+	pollTimersMu.Lock()
+	timer, open := pollTimers[pl.MessageID]
+	if open {
+		timer.Stop()
+		delete(pollTimers, pl.MessageID)
+	}
+	pollTimersMu.Unlock()
+	if !open {
+		return
+	}
+
+	// 結果を投稿できるまでは capsule から消さず、失敗した場合は時間をおいて締め切り直す
+	result := pl.Tally()
+	if result == nil {
+		log.Println(color.HiYellowString("[failed to tally poll %s in Close()] retry in %s", pl.MessageID, pollRetryDelay))
+		pl.retry()
+		return
+	}
+	ms := &Message{ID: pl.MessageID}
+	ms.Edit(pl.content() + "\n**Closed**")
+	if len(GetChannel(pl.ChannelID).Send(result.String())) == 0 {
+		log.Println(color.HiYellowString("[failed to post result of poll %s in Close()] retry in %s", pl.MessageID, pollRetryDelay))
+		pl.retry()
+		return
+	}
+
+	if cps.Db != nil {
+		if err := cps.DeleteRecord(pollKey(pl.MessageID)); err != nil {
+			log.Println(color.HiYellowString("[failed to delete poll %s in Close()] %s", pl.MessageID, err))
+		}
+	}
+}
+
+// 締め切りに失敗した投票を、時間をおいて締め切り直す
+func (pl *Poll) retry() {
+	pl.closeAfter(pollRetryDelay)
+}
+
+// 集計結果を投稿用のテキストにする
+func (res *PollResult) String() string {
+	lines := []string{fmt.Sprintf("**Result: %s**", res.Poll.Question)}
+	for i, choice := range res.Poll.Choices {
+		names := []string{}
+		for _, user := range res.Votes[i] {
+			names = append(names, user.Name)
+		}
+		slices.Sort(names)
+		lines = append(lines, fmt.Sprintf(":%s: %s — %d %s", pollStamps[i], choice, len(names), strings.Join(names, ", ")))
+	}
+	if len(res.Invalid) > 0 {
+		lines = append(lines, fmt.Sprintf("(%d invalid votes with more than one choice)", len(res.Invalid)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (pl *Poll) content() string {
+	lines := []string{fmt.Sprintf("**%s**", pl.Question)}
+	for i, choice := range pl.Choices {
+		lines = append(lines, fmt.Sprintf(":%s: %s", pollStamps[i], choice))
+	}
+	rule := "single choice"
+	if pl.Multiple {
+		rule = "multiple choice"
+	}
+	deadline := pl.Deadline
+	if jst, err := time.LoadLocation("Asia/Tokyo"); err == nil {
+		deadline = deadline.In(jst)
+	}
+	lines = append(lines, fmt.Sprintf("Deadline: %s (%s)", deadline.Format("2006/01/02 15:04"), rule))
+	return strings.Join(lines, "\n")
+}
+
+func (pl *Poll) schedule() {
+	pl.closeAfter(time.Until(pl.Deadline))
+}
+
+func (pl *Poll) closeAfter(delay time.Duration) {
+	pollTimersMu.Lock()
+	defer pollTimersMu.Unlock()
+	if _, exists := pollTimers[pl.MessageID]; exists {
+		return
+	}
+	pollTimers[pl.MessageID] = time.AfterFunc(delay, pl.Close)
+}
+
+func pollKey(msID string) string {
+	return "poll/" + msID
+}
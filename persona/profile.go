@@ -0,0 +1,94 @@
+package persona
+
+// ユーザーの詳しい情報を取得するための関数
+// User 型は名前と UUID だけの軽い型として使い回し、自己紹介やタグなどが必要な場合に限って Profile で取得する
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/fatih/color"
+	traq "github.com/traPtitech/go-traq"
+)
+
+// ユーザーの詳しい情報を表す型
+type Profile struct {
+	User        *User     `json:"user"`
+	IconFileID  string    `json:"iconfileid"`  // アイコン画像のファイルの UUID
+	Bio         string    `json:"bio"`         // 自己紹介
+	State       string    `json:"state"`       // "active"（有効）, "suspended"（一時停止）, "deactivated"（停止）
+	HomeChannel *Channel  `json:"homechannel"` // 設定されていなければ nil
+	Tags        []string  `json:"tags"`        // ["きつね", "Go"]
+	TwitterID   string    `json:"twitterid"`
+	LastOnline  time.Time `json:"lastonline"` // 不明ならゼロ値
+	UpdatedAt   time.Time `json:"updatedat"`
+}
+
+// ユーザーの詳しい情報を取得
+func (us *User) Profile() *Profile {
+	if us == nil {
+		return nil
+	}
+	resp, _, err := Wsbot.API().UserApi.GetUser(context.Background(), us.ID).Execute()
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to get profile of @%s in Profile()] %s", us.Name, err))
+		return nil
+	}
+
+	jst, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to load location in Profile()] %s", err))
+		return nil
+	}
+
+	profile := &Profile{
+		User: &User{
+			Nick:  resp.DisplayName,
+			Name:  resp.Name,
+			ID:    resp.Id,
+			IsBot: resp.Bot,
+		},
+		IconFileID: resp.IconFileId,
+		Bio:        resp.Bio,
+		State:      accountState(resp.State),
+		Tags:       []string{},
+		TwitterID:  resp.TwitterId,
+		UpdatedAt:  resp.UpdatedAt.In(jst),
+	}
+	for _, tag := range resp.Tags {
+		profile.Tags = append(profile.Tags, tag.Tag)
+	}
+	if lastOnline := resp.LastOnline.Get(); lastOnline != nil {
+		profile.LastOnline = lastOnline.In(jst)
+	}
+	if home := resp.HomeChannel.Get(); home != nil {
+		profile.HomeChannel = GetChannel(*home)
+	}
+	return profile
+}
+
+// ユーザーのアイコン画像をダウンロードする。読み終わったら Close すること
+func (us *User) Icon() (io.ReadCloser, error) {
+	if us == nil {
+		return nil, fmt.Errorf("user is nil")
+	}
+	file, _, err := Wsbot.API().UserApi.GetUserIcon(context.Background(), us.ID).Execute()
+	if err != nil {
+		return nil, fmt.Errorf("failed to download icon of @%s: %w", us.Name, err)
+	}
+	return &tempFile{file}, nil // Attachment.Open と同じく、Close の際に一時ファイルを削除する
+}
+
+func accountState(state traq.UserAccountState) string {
+	switch state {
+	case traq.USERACCOUNTSTATE_active:
+		return "active"
+	case traq.USERACCOUNTSTATE_suspended:
+		return "suspended"
+	default:
+		return "deactivated"
+	}
+}
@@ -0,0 +1,101 @@
+package persona
+
+// コマンドを呼び出したメッセージが編集されたときにコマンドを実行し直す仕組み
+// RerunOnEdit を true にすると、Bot に宛てたメッセージ（存在しないコマンドの呼び出しを含む）を記録しておき、
+// 記録したメッセージの MESSAGE_UPDATED イベントを受け取ったときに改めてコマンドとして解釈・実行する
+// コマンドの中で ms.Respond を使って返信していれば、再実行時には新しく投稿する代わりに前回の返信を編集する
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// true にすると、コマンドを呼び出したメッセージが編集されたときにコマンドを実行し直す
+var RerunOnEdit = false
+
+// 編集による再実行を受け付ける期間。これより古い呼び出しは記録から消す
+var RerunWindow = 24 * time.Hour
+
+// コマンドの呼び出しの記録
+type invocation struct {
+	at      time.Time
+	replyID string // ms.Respond による Bot の返信の UUID。まだ返信していなければ空
+}
+
+var (
+	invocations   = map[string]*invocation{} // 呼び出したメッセージの UUID をキーとする
+	invocationsMu sync.Mutex
+)
+
+func trackInvocation(msID string) {
+	invocationsMu.Lock()
+	defer invocationsMu.Unlock()
+
+	for id, inv := range invocations {
+		if time.Since(inv.at) > RerunWindow {
+			delete(invocations, id)
+		}
+	}
+	if _, exists := invocations[msID]; !exists {
+		invocations[msID] = &invocation{at: time.Now()}
+	}
+}
+
+func isTracked(msID string) bool {
+	invocationsMu.Lock()
+	defer invocationsMu.Unlock()
+	_, exists := invocations[msID]
+	return exists
+}
+
+// 編集されたメッセージが記録された呼び出しであれば、コマンドとして解釈し直して実行する
+func rerun(ms *Message) {
+	if !RerunOnEdit || !isTracked(ms.ID) {
+		return
+	}
+	if command, option, _ := findCommand(ms); command != nil {
+		runCommand(ms, command, option)
+	}
+}
+
+// メッセージと同じチャンネルに返信を投稿する
+// RerunOnEdit が true で、このメッセージがコマンドの呼び出しとして記録されている場合は、
+// 同じメッセージに対する前回の返信があればそれを編集して返信とする
+func (ms *Message) Respond(content string) *Message {
+	if ms == nil {
+		return nil
+	}
+
+	invocationsMu.Lock()
+	inv, tracked := invocations[ms.ID]
+	replyID := ""
+	if tracked {
+		replyID = inv.replyID
+	}
+	invocationsMu.Unlock()
+
+	if replyID != "" {
+		if reply := GetMessage(replyID); reply != nil {
+			reply.Edit(content)
+			reply.Text = content
+			return reply
+		}
+		// 前回の返信が削除されていた場合などは新しく投稿する
+	}
+
+	reply, err := ms.Channel.post(content)
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to respond to message %s in Respond()] %s", ms.ID, err))
+		return nil
+	}
+
+	if tracked {
+		invocationsMu.Lock()
+		inv.replyID = reply.ID
+		invocationsMu.Unlock()
+	}
+	return reply
+}
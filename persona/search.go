@@ -0,0 +1,110 @@
+package persona
+
+// traQ のメッセージ検索を使うための関数
+// 検索結果もメッセージ履歴と同じく、必要になった分だけページ単位で取得する
+
+import (
+	"context"
+	"fmt"
+	"iter"
+	"slices"
+	"time"
+)
+
+// SearchMessages は一度に 100 件までしか取得できないので 100 刻みに取得する
+const searchPage = 100
+
+// Search で検索する条件。ゼロ値の項目は条件に含めない
+type SearchQuery struct {
+	Word           string    // 検索ワード。"go -java" のように Simple Query String の構文が使える
+	In             *Channel  // 投稿されたチャンネル
+	From           *User     // 投稿したユーザー
+	To             *User     // メンションされたユーザー
+	MentionsMe     bool      // Bot 自身がメンションされたメッセージに限る。To とは同時に指定できない
+	After          time.Time // これより後に投稿されたメッセージに限る
+	Before         time.Time // これより前に投稿されたメッセージに限る
+	HasAttachments bool      // ファイルが添付されたメッセージに限る
+	Sort           string    // "createdAt"（新しい順）, "-createdAt"（古い順）, "updatedAt", "-updatedAt"。空なら "createdAt"
+}
+
+// 条件に合うメッセージを順に返すイテレータ。ループを抜けるまで必要な分だけ API から取得する
+// for ms, err := range prs.Search(ctx, prs.SearchQuery{Word: "カレー"}) { ... } のように使う
+func Search(ctx context.Context, query SearchQuery) iter.Seq2[*Message, error] {
+	return func(yield func(*Message, error) bool) {
+		if (query.Sort != "") && !slices.Contains([]string{"createdAt", "-createdAt", "updatedAt", "-updatedAt"}, query.Sort) {
+			yield(nil, fmt.Errorf("invalid sort '%s'", query.Sort))
+			return
+		}
+		sort := query.Sort
+		if sort == "" {
+			sort = "createdAt" // 指定しなければサーバーの既定の順序になるので、新しい順を明示する
+		}
+		to := query.To
+		if query.MentionsMe {
+			if to != nil {
+				yield(nil, fmt.Errorf("To and MentionsMe cannot be specified together"))
+				return
+			}
+			if to = getMe(); to == nil {
+				yield(nil, fmt.Errorf("failed to get the bot itself"))
+				return
+			}
+		}
+
+		rs, err := newResolver()
+		if err != nil {
+			yield(nil, err)
+			return
+		}
+		channels := map[string]*Channel{} // 検索結果は様々なチャンネルにまたがるので、チャンネルも使い回す
+		if query.In != nil {
+			channels[query.In.ID] = query.In
+		}
+
+		for offset := 0; ; offset += searchPage {
+			request := Wsbot.API().MessageApi.SearchMessages(ctx).Limit(searchPage).Offset(int32(offset))
+			if query.Word != "" {
+				request = request.Word(query.Word)
+			}
+			if query.In != nil {
+				request = request.In(query.In.ID)
+			}
+			if query.From != nil {
+				request = request.From(query.From.ID)
+			}
+			if to != nil {
+				request = request.To(to.ID)
+			}
+			if !query.After.IsZero() {
+				request = request.After(query.After)
+			}
+			if !query.Before.IsZero() {
+				request = request.Before(query.Before)
+			}
+			if query.HasAttachments {
+				request = request.HasAttachments(true)
+			}
+			request = request.Sort(sort)
+
+			resp, _, err := request.Execute()
+			if err != nil {
+				yield(nil, fmt.Errorf("failed to search messages: %w", err))
+				return
+			}
+
+			for _, hit := range resp.Hits {
+				ch, exists := channels[hit.ChannelId]
+				if !exists {
+					ch = GetChannel(hit.ChannelId)
+					channels[hit.ChannelId] = ch // 取得に失敗した場合も nil を記録して何度も試さないようにする
+				}
+				if !yield(rs.message(&hit, ch), nil) {
+					return
+				}
+			}
+			if (len(resp.Hits) < searchPage) || (int64(offset+searchPage) >= resp.TotalHits) {
+				return
+			}
+		}
+	}
+}
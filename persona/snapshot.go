@@ -0,0 +1,250 @@
+package persona
+
+// traQ の BOT_MESSAGE_STAMPS_UPDATED イベントは「現在ついている全てのスタンプ」しか教えてくれないので、
+// Bot の投稿ごとにスタンプのスナップショットを保持し、前回との差分から追加・削除されたスタンプを求める
+// スナップショットのない投稿（Bot の起動前の投稿など）は、スタンプがひとつもついていなかったものとして扱う
+// スナップショットは SnapshotTTL の間スタンプが変化しなければ破棄する。メニューなどが監視している投稿のものは残す
+
+import (
+	"log"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+	cps "github.com/kitsne241/go-qourier/capsule"
+	payload "github.com/traPtitech/traq-ws-bot/payload"
+)
+
+// Bot の投稿にスタンプが追加されたときに呼ばれる関数。Stamp.Count は追加後の数
+var OnStampAdded func(*Message, *Stamp, *User)
+
+// Bot の投稿からスタンプが削除されたときに呼ばれる関数。Stamp.Count は削除前の数
+var OnStampRemoved func(*Message, *Stamp, *User)
+
+// true にするとスナップショットを capsule にも保存し、Bot を再起動しても差分を正しく求められるようにする
+var PersistStamps = false
+
+// 最後にスタンプが変化してからこの期間が過ぎた投稿のスナップショットは、メモリからも capsule からも破棄する
+var SnapshotTTL = 24 * time.Hour
+
+// 古いスナップショットを探して破棄する間隔
+const snapshotPruneInterval = time.Hour
+
+// ある投稿に、あるユーザーがあるスタンプをいくつつけたか
+type stampCount struct {
+	StampID string `json:"stampid"`
+	UserID  string `json:"userid"`
+	Count   int    `json:"count"`
+}
+
+type snapshot struct {
+	Stamps    []stampCount `json:"stamps"`
+	EventTime time.Time    `json:"eventtime"` // このスナップショットのもとになったイベントの発生日時
+}
+
+var (
+	snapshots   = map[string]*snapshot{} // メッセージの UUID をキーとする
+	snapshotsMu sync.Mutex
+	prunedAt    time.Time // 最後に古いスナップショットを破棄した日時
+)
+
+// メニューなど、特定の投稿へのスタンプの追加・削除を内部で受け取る関数
+type stampWatcher func(ms *Message, st *Stamp, us *User, added bool)
+
+var (
+	stampWatchers   = map[string]stampWatcher{} // メッセージの UUID をキーとする
+	stampWatchersMu sync.RWMutex
+)
+
+func watchStamps(msID string, watcher stampWatcher) {
+	stampWatchersMu.Lock()
+	defer stampWatchersMu.Unlock()
+	stampWatchers[msID] = watcher
+}
+
+func unwatchStamps(msID string) {
+	stampWatchersMu.Lock()
+	defer stampWatchersMu.Unlock()
+	delete(stampWatchers, msID)
+}
+
+func notifyStampWatchers(ms *Message, st *Stamp, us *User, added bool) {
+	stampWatchersMu.RLock()
+	watcher, exists := stampWatchers[ms.ID]
+	stampWatchersMu.RUnlock()
+	if exists {
+		watcher(ms, st, us, added)
+	}
+}
+
+// スナップショットを新しいスタンプの一覧で置き換え、追加・削除されたスタンプを返す
+// 並行して届いたイベントの順序が入れ替わった場合、古いイベントは無視する
+func updateSnapshot(p *payload.BotMessageStampsUpdated) (added []stampCount, removed []stampCount) {
+	snapshotsMu.Lock()
+	defer snapshotsMu.Unlock()
+
+	if time.Since(prunedAt) > snapshotPruneInterval {
+		prunedAt = time.Now()
+		go pruneSnapshots(prunedAt.Add(-SnapshotTTL)) // capsule の読み書きでイベントの処理を止めないよう別の goroutine で行う
+	}
+
+	key := "stamps/" + p.MessageID
+	before, exists := snapshots[p.MessageID]
+	if !exists && PersistStamps && (cps.Db != nil) {
+		saved, found, err := cps.LoadRecord[*snapshot](key)
+		if err != nil {
+			log.Println(color.HiYellowString("[failed to load stamp snapshot of %s] %s", p.MessageID, err))
+		}
+		if found {
+			before, exists = saved, true
+		}
+	}
+	if !exists {
+		before = &snapshot{}
+	}
+	if p.EventTime.Before(before.EventTime) {
+		return nil, nil
+	}
+
+	after := &snapshot{Stamps: []stampCount{}, EventTime: p.EventTime}
+	for _, mstamp := range p.Stamps {
+		after.Stamps = append(after.Stamps, stampCount{StampID: mstamp.StampID, UserID: mstamp.UserID, Count: mstamp.Count})
+	}
+	snapshots[p.MessageID] = after
+
+	if PersistStamps && (cps.Db != nil) {
+		if err := cps.SaveRecord(key, after); err != nil {
+			log.Println(color.HiYellowString("[failed to save stamp snapshot of %s] %s", p.MessageID, err))
+		}
+	}
+
+	return diffStamps(before.Stamps, after.Stamps)
+}
+
+// cutoff より前にスタンプが変化したきりのスナップショットを破棄する
+// capsule に保存されたものは、再起動の前に作られてメモリに読み込まれていないものも含めて調べる
+func pruneSnapshots(cutoff time.Time) {
+	stampWatchersMu.RLock()
+	watched := map[string]bool{}
+	for msID := range stampWatchers {
+		watched[msID] = true
+	}
+	stampWatchersMu.RUnlock()
+
+	expired := []string{}
+	snapshotsMu.Lock()
+	for msID, snap := range snapshots {
+		if !watched[msID] && snap.EventTime.Before(cutoff) {
+			delete(snapshots, msID)
+			expired = append(expired, "stamps/"+msID)
+		}
+	}
+	snapshotsMu.Unlock()
+
+	if !PersistStamps || (cps.Db == nil) {
+		return
+	}
+	names, err := cps.RecordNames("stamps/")
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to list stamp snapshots in pruneSnapshots()] %s", err))
+		return
+	}
+	for _, key := range names {
+		msID := strings.TrimPrefix(key, "stamps/")
+		if watched[msID] || slices.Contains(expired, key) {
+			continue
+		}
+		saved, found, err := cps.LoadRecord[*snapshot](key)
+		if (err == nil) && found && saved.EventTime.Before(cutoff) {
+			expired = append(expired, key)
+		}
+	}
+
+	for _, key := range expired {
+		snapshotsMu.Lock()
+		_, renewed := snapshots[strings.TrimPrefix(key, "stamps/")] // 調べている間に新しいイベントが届いていれば残す
+		snapshotsMu.Unlock()
+		if renewed {
+			continue
+		}
+		if err := cps.DeleteRecord(key); err != nil {
+			log.Println(color.HiYellowString("[failed to delete stamp snapshot %s in pruneSnapshots()] %s", key, err))
+		}
+	}
+}
+
+// 同じスタンプを同じユーザーが重ねてつけた場合（Count の増加）も追加として扱う
+func diffStamps(before []stampCount, after []stampCount) (added []stampCount, removed []stampCount) {
+	type pair struct{ stampID, userID string }
+
+	counts := map[pair]int{}
+	for _, sc := range before {
+		counts[pair{sc.StampID, sc.UserID}] = sc.Count
+	}
+	for _, sc := range after {
+		key := pair{sc.StampID, sc.UserID}
+		if sc.Count > counts[key] {
+			added = append(added, sc)
+		}
+		delete(counts, key)
+	}
+	for _, sc := range before {
+		if _, remains := counts[pair{sc.StampID, sc.UserID}]; remains {
+			removed = append(removed, sc)
+		}
+	}
+	return added, removed
+}
+
+// 差分を *Stamp と *User に変換してイベントを発生させる
+func emitStampDiff(ms *Message, added []stampCount, removed []stampCount) {
+	if (len(added) == 0) && (len(removed) == 0) {
+		return
+	}
+
+	userDic := map[string]*User{}
+	for _, st := range ms.Stamps {
+		if st.User != nil {
+			userDic[st.User.ID] = st.User
+		}
+	}
+	// 追加されたスタンプを押したユーザーは GetMessage で取得済み。削除された場合のみ改めて取得する
+	getUser := func(usID string) *User {
+		if _, exists := userDic[usID]; !exists {
+			userDic[usID] = GetUser(usID)
+		}
+		return userDic[usID]
+	}
+
+	stampIDName := map[string]string{}
+	if len(removed) > 0 {
+		stampIDName = getAllStamps().Symbol
+	}
+	stampName := func(stID string) string {
+		for _, st := range ms.Stamps {
+			if st.ID == stID {
+				return st.Name
+			}
+		}
+		return stampIDName[stID]
+	}
+
+	for _, sc := range added {
+		user := getUser(sc.UserID)
+		stamp := &Stamp{Name: stampName(sc.StampID), ID: sc.StampID, Count: sc.Count, User: user}
+		notifyStampWatchers(ms, stamp, user, true)
+		if OnStampAdded != nil {
+			OnStampAdded(ms, stamp, user)
+		}
+	}
+	for _, sc := range removed {
+		user := getUser(sc.UserID)
+		stamp := &Stamp{Name: stampName(sc.StampID), ID: sc.StampID, Count: sc.Count, User: user}
+		notifyStampWatchers(ms, stamp, user, false)
+		if OnStampRemoved != nil {
+			OnStampRemoved(ms, stamp, user)
+		}
+	}
+}
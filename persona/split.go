@@ -0,0 +1,166 @@
+package persona
+
+// traQ に投稿できる長さを超えるメッセージを分割するための関数
+// 段落の区切り > 行の区切り > 行の途中 の順に分割する位置を探し、コードブロックや埋め込みの途中では切らない
+// コードブロックの途中で分割せざるを得ない場合は、前の部分でコードブロックを閉じて次の部分で開き直す
+
+import (
+	"strings"
+	"unicode"
+)
+
+// 1 回の投稿に含められる最大の文字数（rune 単位）
+var MaxMessageLength = 10000
+
+func splitMessage(content string, limit int) []string {
+	if len([]rune(content)) <= limit {
+		return []string{content}
+	}
+
+	// ひとつの部分に収まらないほど長い行に限り、あらかじめ分割しておく
+	// コードブロックの中の行は、コードブロックを開き直して閉じる分の余裕を残した長さまでとする
+	// 分割された行の 2 つ目以降は、同じ部分に入った場合に改行を挟まずに前の行とつなげる
+	lines := []string{}
+	continued := map[int]bool{} // lines の添字のうち、前の行の続きであるもの
+	preFence, preOpen := []rune{}, ""
+	for _, line := range strings.Split(content, "\n") {
+		lineRune := []rune(line)
+		room := limit
+		if marker := fenceMarker(lineRune, 0); len(preFence) > 0 {
+			if (len(marker) >= len(preFence)) && (marker[0] == preFence[0]) && isBlank(lineRune, marker) {
+				preFence = []rune{}
+			} else {
+				room = max(limit-len([]rune(preOpen))-len(preFence)-2, 1)
+			}
+		} else if len(marker) >= 3 {
+			preFence, preOpen = marker, line
+		}
+
+		for i, piece := range splitLine(line, room) {
+			continued[len(lines)] = (i > 0)
+			lines = append(lines, piece)
+		}
+	}
+
+	parts := []string{}
+	current := []int{}   // 現在の部分に含める行の lines の添字。-1 はコードブロックを開き直すために加えた行
+	opened := []string{} // current の各行を読み終えた時点で開いているコードブロックの開始行。開いていなければ空
+	reopened := 0        // current の先頭のうち、コードブロックを開き直すために加えた行の数
+	fence := []rune{}    // 開いているコードブロックを閉じるための ``` や ~~~
+
+	join := func(indices []int, open string) string {
+		text := strings.Builder{}
+		for n, i := range indices {
+			switch {
+			case i == -1:
+				text.WriteString(open + "\n") // 開き直すコードブロックの開始行は常に先頭にあり、続く行が前の行の続きであっても改行する
+				continue
+			case (n > 0) && (indices[n-1] != -1) && !continued[i]:
+				text.WriteString("\n")
+			}
+			text.WriteString(lines[i])
+		}
+		return text.String()
+	}
+
+	closing := func(open string) string {
+		marker := fenceMarker([]rune(open), 0)
+		return strings.Repeat(string(marker[0]), len(marker))
+	}
+	carriedOpen := "" // current の先頭で開き直しているコードブロックの開始行
+	size := func(indices []int, open string) int {
+		total := len([]rune(join(indices, carriedOpen)))
+		if open != "" {
+			total += len([]rune(closing(open))) + 1
+		}
+		return total
+	}
+	flush := func(indices []int, open string) {
+		part := join(indices, carriedOpen)
+		if open != "" {
+			part += "\n" + closing(open)
+		}
+		if part = strings.Trim(part, "\n"); strings.TrimSpace(part) != "" {
+			parts = append(parts, part)
+		}
+	}
+
+	for index, line := range lines {
+		lineRune := []rune(line)
+		open := ""
+		if len(opened) > 0 {
+			open = opened[len(opened)-1]
+		}
+		if continued[index] {
+			// 分割された行の続きは行頭ではないので、コードブロックの始まりや終わりにはならない
+		} else if marker := fenceMarker(lineRune, 0); len(fence) > 0 {
+			if (len(marker) >= len(fence)) && (marker[0] == fence[0]) && isBlank(lineRune, marker) {
+				fence, open = []rune{}, "" // コードブロックの終わり
+			}
+		} else if len(marker) >= 3 {
+			fence, open = marker, line // コードブロックの始まり
+		}
+
+		for (size(append(current[:len(current):len(current)], index), open) > limit) && (len(current) > reopened) {
+			// 段落の区切り（コードブロックの外の空行）があればそこで、なければこの行の直前で分割する
+			cut := len(current)
+			for i := len(current) - 1; i > reopened; i-- {
+				if (current[i] != -1) && (strings.TrimSpace(lines[current[i]]) == "") && (opened[i] == "") {
+					cut = i
+					break
+				}
+			}
+			if last := cut - 1; (cut == len(current)) && (last > reopened) && (current[last] != -1) &&
+				(opened[last] != "") && (opened[last-1] == "") {
+				cut = last // コードブロックの開始行で部分が終わる場合は、開始行ごと次の部分に送って空のコードブロックを作らない
+			}
+			carried := opened[cut-1]
+			flush(current[:cut], carried)
+
+			rest, restOpened := current[cut:], opened[cut:]
+			current, opened, reopened, carriedOpen = []int{}, []string{}, 0, carried
+			if carried != "" {
+				current, opened, reopened = append(current, -1), append(opened, carried), 1
+			}
+			current, opened = append(current, rest...), append(opened, restOpened...)
+		}
+		current, opened = append(current, index), append(opened, open)
+	}
+	flush(current, "")
+
+	return parts
+}
+
+// 1 行を limit 以下の長さに分割する。なるべく空白の直後で切り、埋め込みの途中では切らない
+func splitLine(line string, limit int) []string {
+	lineRune := []rune(line)
+	if len(lineRune) <= limit {
+		return []string{line}
+	}
+	_, embeds := Unembed(line)
+
+	pieces := []string{}
+	for start := 0; start < len(lineRune); {
+		if len(lineRune)-start <= limit {
+			pieces = append(pieces, string(lineRune[start:]))
+			break
+		}
+
+		cut := start + limit
+		for _, e := range embeds {
+			if (e.Start < cut) && (cut < e.End) && (e.Start > start) {
+				cut = e.Start // 埋め込みの途中にかかる場合は埋め込みの前で切る
+			}
+		}
+		for i := cut; i > start+limit/2; i-- {
+			if unicode.IsSpace(lineRune[i-1]) {
+				cut = i
+				break
+			}
+		}
+
+		pieces = append(pieces, string(lineRune[start:cut]))
+		start = cut
+	}
+	return pieces
+}
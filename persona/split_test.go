@@ -0,0 +1,135 @@
+package persona
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+const testEmbed = `!{"type":"user","raw":"@kitsne","id":"a77f54f2-a7dc-4dab-ad6d-5c5df7e9ecfa"}`
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		limit   int
+		want    []string
+	}{
+		{
+			name:    "short content is kept as is",
+			content: "hello\n\nworld",
+			limit:   20,
+			want:    []string{"hello\n\nworld"},
+		},
+		{
+			name:    "split at paragraph",
+			content: "para one\nline two\n\npara three",
+			limit:   20,
+			want:    []string{"para one\nline two", "para three"},
+		},
+		{
+			name:    "split at line without paragraph",
+			content: "line one\nline two\nline three",
+			limit:   20,
+			want:    []string{"line one\nline two", "line three"},
+		},
+		{
+			name:    "line that fits in next part is not broken",
+			content: "aaaaaaaaa\nbbbb bbbb bbbb",
+			limit:   20,
+			want:    []string{"aaaaaaaaa", "bbbb bbbb bbbb"},
+		},
+		{
+			name:    "opening fence moves to next part",
+			content: "aaaaa\n```\nbbbbbbbbbbbbb\n```",
+			limit:   24,
+			want:    []string{"aaaaa", "```\nbbbbbbbbbbbbb\n```"},
+		},
+		{
+			name:    "code fence is closed and reopened",
+			content: "```go\nline1\nline2\nline3\nline4\n```\nafter",
+			limit:   20,
+			want:    []string{"```go\nline1\n```", "```go\nline2\n```", "```go\nline3\n```", "```go\nline4\n```", "after"},
+		},
+		{
+			name:    "long line inside code fence",
+			content: "```\n" + strings.Repeat("y", 60) + "\n```",
+			limit:   20,
+			want:    slices.Repeat([]string{"```\n" + strings.Repeat("y", 12) + "\n```"}, 5),
+		},
+		{
+			name:    "long line inside tilde fence",
+			content: "~~~~\ncode ~~~ here\nmore\n~~~~\nafter",
+			limit:   20,
+			want:    []string{"~~~~\ncode ~~~ \n~~~~", "~~~~\nhere\nmore\n~~~~", "after"},
+		},
+		{
+			name:    "embed at cut point of long line is kept whole",
+			content: strings.Repeat("i", 60) + "\n\n" + strings.Repeat("a", 60) + testEmbed + " tail",
+			limit:   200,
+			want:    []string{strings.Repeat("i", 60), strings.Repeat("a", 60) + testEmbed + " tail"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitMessage(tt.content, tt.limit)
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("splitMessage(%q, %d)\n got  %q\n want %q", tt.content, tt.limit, got, tt.want)
+			}
+			for _, part := range got {
+				if len([]rune(part)) > tt.limit {
+					t.Errorf("part %q is longer than %d", part, tt.limit)
+				}
+			}
+		})
+	}
+}
+
+func TestSplitLine(t *testing.T) {
+	tests := []struct {
+		name  string
+		line  string
+		limit int
+		want  []string
+	}{
+		{
+			name:  "short line",
+			line:  "hello",
+			limit: 10,
+			want:  []string{"hello"},
+		},
+		{
+			name:  "cut after space",
+			line:  "hello world foo bar baz",
+			limit: 10,
+			want:  []string{"hello ", "world foo ", "bar baz"},
+		},
+		{
+			name:  "cut without space",
+			line:  strings.Repeat("y", 25),
+			limit: 10,
+			want:  []string{strings.Repeat("y", 10), strings.Repeat("y", 10), strings.Repeat("y", 5)},
+		},
+		{
+			name:  "embed at cut point is moved to next piece",
+			line:  strings.Repeat("x", 50) + " " + testEmbed + " tail",
+			limit: 100,
+			want:  []string{strings.Repeat("x", 50) + " ", testEmbed + " tail"},
+		},
+		{
+			name:  "multibyte runes",
+			line:  strings.Repeat("きつね", 5),
+			limit: 6,
+			want:  []string{"きつねきつね", "きつねきつね", "きつね"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := splitLine(tt.line, tt.limit); !slices.Equal(got, tt.want) {
+				t.Errorf("splitLine(%q, %d)\n got  %q\n want %q", tt.line, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
@@ -62,3 +62,22 @@ func (ms *Message) Stamp(stamps ...string) {
 		}
 	}
 }
+
+// メッセージから Bot 自身がつけた引数のスタンプを外す
+func (ms *Message) Unstamp(stamps ...string) {
+	if ms == nil {
+		return
+	}
+	stampNameID := getAllStamps().ID
+	for _, stamp := range stamps {
+		stID, exists := stampNameID[stamp]
+		if !exists {
+			log.Println(color.HiYellowString("[failed to remove stamp from post in Unstamp(\"%s\")] stamp \"%s\" not found", stamp, stamp))
+			continue
+		}
+		_, err := Wsbot.API().MessageApi.RemoveMessageStamp(context.Background(), ms.ID, stID).Execute()
+		if err != nil {
+			log.Println(color.HiYellowString("[failed to remove stamp from post in Unstamp(\"%s\")] %s", stamp, err))
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package persona
+
+// チャンネルの親子関係をまとめて扱うための型
+// GetChannel は祖先のチャンネルの数だけ API を呼び出すので、多くのチャンネルを辿る場合は
+// GetChannels の 1 回の呼び出しから作った ChannelTree を使い回すほうがよい
+
+import (
+	"log"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// 取得した時点の全てのパブリックチャンネルの木構造。取得後に作成・変更されたチャンネルは反映されない
+type ChannelTree struct {
+	byID     map[string]*Channel   // チャンネルの UUID と Channel 型との対応
+	byPath   map[string]*Channel   // チャンネルのパスと Channel 型との対応
+	children map[string][]*Channel // 親チャンネルの UUID と子チャンネルの配列（名前順）との対応。最上位のチャンネルは "" に入る
+}
+
+// 全てのパブリックチャンネルの木構造を取得
+func GetChannelTree() *ChannelTree {
+	return getAllChannels()
+}
+
+// 引数の UUID をもつチャンネルを取得
+func (tr *ChannelTree) Channel(chID string) *Channel {
+	return tr.byID[chID]
+}
+
+// 引数のパスをもつチャンネルを取得
+func (tr *ChannelTree) Path(path string) *Channel {
+	return tr.byPath[path]
+}
+
+// 親チャンネルを取得。最上位のチャンネルなら nil
+func (tr *ChannelTree) Parent(ch *Channel) *Channel {
+	if (ch == nil) || (tr.byID[ch.ID] == nil) {
+		return nil
+	}
+	return tr.byID[ch.ID].Parent
+}
+
+// 子チャンネルの配列を名前順に取得。引数が nil なら最上位のチャンネルの配列を返す
+func (tr *ChannelTree) Children(ch *Channel) []*Channel {
+	parentID := ""
+	if ch != nil {
+		parentID = ch.ID
+	}
+	return slices.Clone(tr.children[parentID])
+}
+
+// 子孫のチャンネルの配列を、親が子より先に来る順で取得。引数が nil なら全てのチャンネルを返す
+func (tr *ChannelTree) Descendants(ch *Channel) []*Channel {
+	descendants := []*Channel{}
+	tr.walk(tr.Children(ch), func(c *Channel) bool {
+		descendants = append(descendants, c)
+		return true
+	})
+	return descendants
+}
+
+// 全てのチャンネルを、親が子より先に来る順で辿って action を実行する
+// action が false を返すとそのチャンネルの子孫は辿らない
+func (tr *ChannelTree) Walk(action func(*Channel) bool) {
+	tr.walk(tr.Children(nil), action)
+}
+
+func (tr *ChannelTree) walk(channels []*Channel, action func(*Channel) bool) {
+	for _, ch := range channels {
+		if action(ch) {
+			tr.walk(tr.children[ch.ID], action)
+		}
+	}
+}
+
+// パスが glob パターンに一致するチャンネルの配列をパス順に取得。"gps/times/*" のように指定する
+func (tr *ChannelTree) Glob(pattern string) []*Channel {
+	if _, err := path.Match(pattern, ""); err != nil {
+		log.Println(color.HiYellowString("[failed to compile pattern in Glob(\"%s\")] %s", pattern, err))
+		return []*Channel{}
+	}
+	matched := []*Channel{}
+	for chPath, ch := range tr.byPath {
+		if ok, _ := path.Match(pattern, chPath); ok {
+			matched = append(matched, ch)
+		}
+	}
+	slices.SortFunc(matched, func(a, b *Channel) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	return matched
+}
@@ -0,0 +1,349 @@
+package persona
+
+// traQ 内部で使われている Unembedder（TypeScript で書かれている）を参考に、Markdown の構造を考慮して Go で再実装
+// traq-ws-bot でメッセージイベントを受け取った時には PlainText を取得できるが、go-traq の API としては提供されていない
+// https://github.com/traPtitech/traQ_S-UI/blob/master/src/lib/markdown/internalLinkUnembedder.ts
+// 基本的に埋め込みは type, raw, id の 3 つのキーのみから構成される JSON 文字列 !{ ... } である
+// コードスパン・コードブロックの中やバックスラッシュでエスケープされた箇所は埋め込みとして扱わない
+// また !{ ... } 以外にも、スタンプ記法とメッセージ・ファイルのリンクを埋め込みの一種として扱う
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+// 埋め込みの種類
+const (
+	EmbedUser    = "user"    // !{"type":"user","raw":"@kitsne","id":"..."}
+	EmbedChannel = "channel" // !{"type":"channel","raw":"#gps/times/kitsnegra","id":"..."}
+	EmbedGroup   = "group"   // !{"type":"group","raw":"@SysAd","id":"..."}
+	EmbedStamp   = "stamp"   // :tada: や :tada.ex-large: など。ID は空で、名前は Raw から分かる
+	EmbedFile    = "file"    // https://q.trap.jp/files/UUID
+	EmbedMessage = "message" // https://q.trap.jp/messages/UUID
+)
+
+// メッセージ中の埋め込みを表す型
+type Embed struct {
+	Type  string `json:"type"`  // "user"
+	Raw   string `json:"raw"`   // "@BOT_nek"
+	ID    string `json:"id"`    // "0192d23e-2fb1-764b-ba7d-dbabd1185e00"
+	Start int    `json:"start"` // 埋め込みの開始位置（rune 単位）
+	End   int    `json:"end"`   // 埋め込みの終了位置（rune 単位）
+}
+
+// メッセージ本文を埋め込みのないもとの Markdown の形式に変換する
+// スタンプやリンクは埋め込みの一覧には含めるが、本文ではそのままの形で残す
+func Unembed(text string) (string, []Embed) {
+	textRune := []rune(text)
+	embeds := []Embed{}
+
+	linkPrefix := []rune(origin() + "/")
+
+	lineStart := true
+	fence := []rune{} // コードブロックの中であれば、それを閉じるための ``` や ~~~
+
+	for i := 0; i < len(textRune); {
+		if lineStart {
+			if marker := fenceMarker(textRune, i); len(fence) > 0 {
+				if (len(marker) >= len(fence)) && (marker[0] == fence[0]) && isBlank(textRune[i:lineEnd(textRune, i)], marker) {
+					fence = []rune{} // コードブロックの終わり
+				}
+				i = lineEnd(textRune, i) + 1
+				continue
+			} else if len(marker) >= 3 {
+				fence = marker // コードブロックの始まり
+				i = lineEnd(textRune, i) + 1
+				continue
+			}
+		}
+		lineStart = false
+
+		switch {
+		case textRune[i] == '\n':
+			lineStart = true
+			i++
+
+		case textRune[i] == '\\':
+			i += 2 // エスケープされた文字は読み飛ばす
+
+		case textRune[i] == '`':
+			i = skipCodeSpan(textRune, i)
+
+		case (textRune[i] == '!') && (i+1 < len(textRune)) && (textRune[i+1] == '{'):
+			end := matchBrace(textRune, i+1)
+			data := Embed{}
+			if (end != -1) && (json.Unmarshal([]byte(string(textRune[i+1:end])), &data) == nil) && (data.Type != "") {
+				data.Start, data.End = i, end
+				embeds = append(embeds, data)
+				i = end
+			} else {
+				i++
+			}
+
+		case textRune[i] == ':':
+			if end := matchStamp(textRune, i); end != -1 {
+				embeds = append(embeds, Embed{Type: EmbedStamp, Raw: string(textRune[i:end]), Start: i, End: end})
+				i = end
+			} else {
+				i++
+			}
+
+		case hasPrefixRune(textRune[i:], linkPrefix):
+			if kind, end := matchLink(textRune, i+len(linkPrefix)); end != -1 {
+				id := string(textRune[end-36 : end])
+				embeds = append(embeds, Embed{Type: kind, Raw: string(textRune[i:end]), ID: id, Start: i, End: end})
+				i = end
+			} else {
+				i++
+			}
+
+		default:
+			i++
+		}
+	}
+
+	// 得られた embed を後ろから順に置き換えて埋め込みを解消する
+	for i := len(embeds) - 1; i >= 0; i-- {
+		data := embeds[i]
+		tempRune := append([]rune(data.Raw), textRune[data.End:]...)
+		textRune = append(textRune[:data.Start], tempRune...)
+	}
+
+	return string(textRune), embeds
+}
+
+// 埋め込まれたユーザーを取得。ユーザーへのメンションでなければ nil
+func (e Embed) User() *User {
+	if e.Type != EmbedUser {
+		return nil
+	}
+	return GetUser(e.ID)
+}
+
+// 埋め込まれたチャンネルを取得。チャンネルリンクでなければ nil
+func (e Embed) Channel() *Channel {
+	if e.Type != EmbedChannel {
+		return nil
+	}
+	return GetChannel(e.ID)
+}
+
+// 埋め込まれたグループを取得。グループへのメンションでなければ nil
+func (e Embed) Group() *Group {
+	if e.Type != EmbedGroup {
+		return nil
+	}
+	return GetGroup(e.ID)
+}
+
+// 埋め込まれたスタンプを取得。スタンプでないか、その名前のスタンプが存在しなければ nil
+func (e Embed) Stamp() *Stamp {
+	if e.Type != EmbedStamp {
+		return nil
+	}
+	name, _, _ := strings.Cut(strings.Trim(e.Raw, ":"), ".") // :tada.ex-large: のようなエフェクトを取り除く
+	return NameGetStamp(name)
+}
+
+// リンクされたメッセージを取得。メッセージリンクでなければ nil
+func (e Embed) Message() *Message {
+	if e.Type != EmbedMessage {
+		return nil
+	}
+	return GetMessage(e.ID)
+}
+
+// メッセージでメンションされているユーザーの一覧。同じユーザーは一度だけ含める
+func (ms *Message) Mentions() []*User {
+	users := []*User{}
+	if ms == nil {
+		return users
+	}
+	_, embeds := Unembed(ms.Text)
+	found := map[string]bool{}
+	for _, e := range embeds {
+		if (e.Type != EmbedUser) || found[e.ID] {
+			continue
+		}
+		found[e.ID] = true
+		if user := e.User(); user != nil {
+			users = append(users, user)
+		}
+	}
+	return users
+}
+
+// メッセージでメンションされたグループの配列を重複なく取得
+func (ms *Message) GroupMentions() []*Group {
+	groups := []*Group{}
+	if ms == nil {
+		return groups
+	}
+	_, embeds := Unembed(ms.Text)
+	found := map[string]bool{}
+	for _, e := range embeds {
+		if (e.Type != EmbedGroup) || found[e.ID] {
+			continue
+		}
+		found[e.ID] = true
+		if group := e.Group(); group != nil {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// メッセージで Bot 自身、または Bot が所属するグループがメンションされているか
+func (ms *Message) MentionsMe() bool {
+	if ms == nil {
+		return false
+	}
+	me := getMe()
+	if me == nil {
+		return false
+	}
+	_, embeds := Unembed(ms.Text)
+	if slices.ContainsFunc(embeds, func(e Embed) bool { return (e.Type == EmbedUser) && (e.ID == me.ID) }) {
+		return true
+	}
+	if !slices.ContainsFunc(embeds, func(e Embed) bool { return e.Type == EmbedGroup }) {
+		return false // グループへのメンションがなければ、所属するグループを調べるまでもない
+	}
+	grIDs := me.groupIDs()
+	return slices.ContainsFunc(embeds, func(e Embed) bool { return (e.Type == EmbedGroup) && slices.Contains(grIDs, e.ID) })
+}
+
+// 行頭（3 つまでの空白を許す）にある ``` や ~~~ を返す。なければ空
+func fenceMarker(textRune []rune, i int) []rune {
+	for indent := 0; (indent < 3) && (i < len(textRune)) && (textRune[i] == ' '); indent++ {
+		i++
+	}
+	if (i >= len(textRune)) || ((textRune[i] != '`') && (textRune[i] != '~')) {
+		return []rune{}
+	}
+	start := i
+	for (i < len(textRune)) && (textRune[i] == textRune[start]) {
+		i++
+	}
+	if i-start < 3 {
+		return []rune{}
+	}
+	return textRune[start:i]
+}
+
+// コードブロックを閉じる行は、記号の後に空白以外を含まない
+func isBlank(line []rune, marker []rune) bool {
+	rest := strings.TrimLeft(string(line), " ")
+	return strings.TrimSpace(strings.TrimLeft(rest, string(marker[0]))) == ""
+}
+
+// i を含む行の終わり（改行の位置、なければテキストの末尾）
+func lineEnd(textRune []rune, i int) int {
+	for (i < len(textRune)) && (textRune[i] != '\n') {
+		i++
+	}
+	return i
+}
+
+// i から始まるバッククォートの連続と同じ長さの連続を探し、コードスパンの終わりを返す
+// 閉じられていなければバッククォートの連続だけを読み飛ばす。空行を越えてコードスパンは続かない
+func skipCodeSpan(textRune []rune, i int) int {
+	start := i
+	for (i < len(textRune)) && (textRune[i] == '`') {
+		i++
+	}
+	length := i - start
+
+	for j := i; j < len(textRune); {
+		if (textRune[j] == '\n') && (j+1 < len(textRune)) && (textRune[j+1] == '\n') {
+			break
+		}
+		if textRune[j] != '`' {
+			j++
+			continue
+		}
+		runStart := j
+		for (j < len(textRune)) && (textRune[j] == '`') {
+			j++
+		}
+		if j-runStart == length {
+			return j
+		}
+	}
+	return i
+}
+
+// i にある { に対応する } の次の位置を返す。JSON の文字列の中の括弧やエスケープを考慮する
+// 改行を含む埋め込みはないので、行の終わりまでに見つからなければ -1
+func matchBrace(textRune []rune, i int) int {
+	depth := 0
+	inString := false
+	for ; (i < len(textRune)) && (textRune[i] != '\n'); i++ {
+		switch {
+		case inString && (textRune[i] == '\\'):
+			i++
+		case textRune[i] == '"':
+			inString = !inString
+		case inString:
+		case textRune[i] == '{':
+			depth++
+		case textRune[i] == '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		}
+	}
+	return -1
+}
+
+// i にある : から始まるスタンプ記法 :name: や :name.effect: の終わりの次の位置を返す。なければ -1
+func matchStamp(textRune []rune, i int) int {
+	isNameRune := func(r rune) bool {
+		return ('a' <= r && r <= 'z') || ('A' <= r && r <= 'Z') || ('0' <= r && r <= '9') || (r == '_') || (r == '-')
+	}
+	j := i + 1
+	for (j < len(textRune)) && (isNameRune(textRune[j]) || (textRune[j] == '.')) {
+		j++
+	}
+	name, _, _ := strings.Cut(string(textRune[i+1:j]), ".")
+	if (j >= len(textRune)) || (textRune[j] != ':') || (name == "") || (len(name) > 32) {
+		return -1
+	}
+	if strings.Trim(name, "0123456789") == "" {
+		return -1 // "12:30:45" のような時刻の一部をスタンプとみなさないよう、数字だけの名前は除く
+	}
+	return j + 1
+}
+
+// i から始まる "messages/UUID" や "files/UUID" の種類と、その終わりの次の位置を返す。なければ -1
+func matchLink(textRune []rune, i int) (string, int) {
+	for kind, path := range map[string]string{EmbedMessage: "messages/", EmbedFile: "files/"} {
+		if !hasPrefixRune(textRune[i:], []rune(path)) {
+			continue
+		}
+		end := i + len(path) + 36
+		if (end <= len(textRune)) && isUUID(string(textRune[end-36:end])) {
+			return kind, end
+		}
+	}
+	return "", -1
+}
+
+func isUUID(text string) bool {
+	for i, r := range text {
+		if (i == 8) || (i == 13) || (i == 18) || (i == 23) {
+			if r != '-' {
+				return false
+			}
+		} else if !strings.ContainsRune("0123456789abcdefABCDEF", r) {
+			return false
+		}
+	}
+	return len(text) == 36
+}
+
+func hasPrefixRune(textRune []rune, prefix []rune) bool {
+	return (len(textRune) >= len(prefix)) && slices.Equal(textRune[:len(prefix)], prefix)
+}
@@ -0,0 +1,113 @@
+package persona
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestUnembed(t *testing.T) {
+	const userID = "a77f54f2-a7dc-4dab-ad6d-5c5df7e9ecfa"
+	const msID = "0192d23e-2fb1-764b-ba7d-dbabd1185e00"
+	mention := `!{"type":"user","raw":"@kitsne","id":"` + userID + `"}`
+
+	tests := []struct {
+		name   string
+		text   string
+		plain  string
+		embeds []Embed
+	}{
+		{
+			name:   "no embed",
+			text:   "hello world",
+			plain:  "hello world",
+			embeds: []Embed{},
+		},
+		{
+			name:   "user mention",
+			text:   mention + " hello",
+			plain:  "@kitsne hello",
+			embeds: []Embed{{Type: EmbedUser, Raw: "@kitsne", ID: userID, Start: 0, End: 76}},
+		},
+		{
+			name:   "brace inside json string",
+			text:   `!{"type":"user","raw":"@a}b","id":"` + userID + `"}`,
+			plain:  "@a}b",
+			embeds: []Embed{{Type: EmbedUser, Raw: "@a}b", ID: userID, Start: 0, End: 73}},
+		},
+		{
+			name:   "escaped quote inside json string",
+			text:   `!{"type":"user","raw":"@a\"}","id":"` + userID + `"}`,
+			plain:  `@a"}`,
+			embeds: []Embed{{Type: EmbedUser, Raw: `@a"}`, ID: userID, Start: 0, End: 74}},
+		},
+		{
+			name:   "rune offsets after multibyte text",
+			text:   "きつね " + mention,
+			plain:  "きつね @kitsne",
+			embeds: []Embed{{Type: EmbedUser, Raw: "@kitsne", ID: userID, Start: 4, End: 80}},
+		},
+		{
+			name:   "code span is not parsed",
+			text:   "`" + mention + "`",
+			plain:  "`" + mention + "`",
+			embeds: []Embed{},
+		},
+		{
+			name:   "code fence is not parsed",
+			text:   "```\n" + mention + "\n:tada:\n```\n:tada:",
+			plain:  "```\n" + mention + "\n:tada:\n```\n:tada:",
+			embeds: []Embed{{Type: EmbedStamp, Raw: ":tada:", Start: 4 + 76 + 1 + 6 + 1 + 3 + 1, End: 4 + 76 + 1 + 6 + 1 + 3 + 1 + 6}},
+		},
+		{
+			name:   "escaped embed is not parsed",
+			text:   `\` + mention,
+			plain:  `\` + mention,
+			embeds: []Embed{},
+		},
+		{
+			name:  "stamps with effect",
+			text:  "nice :tada.ex-large: :done-nya:",
+			plain: "nice :tada.ex-large: :done-nya:",
+			embeds: []Embed{
+				{Type: EmbedStamp, Raw: ":tada.ex-large:", Start: 5, End: 20},
+				{Type: EmbedStamp, Raw: ":done-nya:", Start: 21, End: 31},
+			},
+		},
+		{
+			name:   "time is not a stamp",
+			text:   "at 12:30:45",
+			plain:  "at 12:30:45",
+			embeds: []Embed{},
+		},
+		{
+			name:   "message link",
+			text:   "see https://q.trap.jp/messages/" + msID,
+			plain:  "see https://q.trap.jp/messages/" + msID,
+			embeds: []Embed{{Type: EmbedMessage, Raw: "https://q.trap.jp/messages/" + msID, ID: msID, Start: 4, End: 67}},
+		},
+		{
+			name:   "file link",
+			text:   "https://q.trap.jp/files/" + msID,
+			plain:  "https://q.trap.jp/files/" + msID,
+			embeds: []Embed{{Type: EmbedFile, Raw: "https://q.trap.jp/files/" + msID, ID: msID, Start: 0, End: 60}},
+		},
+		{
+			name:   "broken embed",
+			text:   `!{"type":"user"`,
+			plain:  `!{"type":"user"`,
+			embeds: []Embed{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plain, embeds := Unembed(tt.text)
+			if plain != tt.plain {
+				t.Errorf("Unembed(%q) text\n got  %q\n want %q", tt.text, plain, tt.plain)
+			}
+			if !slices.Equal(embeds, tt.embeds) {
+				t.Errorf("Unembed(%q) embeds\n got  %+v\n want %+v", tt.text, embeds, tt.embeds)
+			}
+		})
+	}
+}
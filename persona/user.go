@@ -3,6 +3,7 @@ package persona
 import (
 	"context"
 	"log"
+	"sync"
 
 	"github.com/fatih/color"
 )
@@ -57,3 +58,49 @@ func GetMe() *User {
 		IsBot: true,
 	}
 }
+
+var (
+	myself   *User
+	myselfMu sync.Mutex
+)
+
+// Bot 自身のユーザーを、一度取得したら使い回して返す。投稿のたびに GetMe を呼ばないようにするためのもの
+func getMe() *User {
+	myselfMu.Lock()
+	defer myselfMu.Unlock()
+	if myself == nil {
+		myself = GetMe() // 取得に失敗した場合は次に呼ばれたときに取得し直す
+	}
+	return myself
+}
+
+// ユーザーとの DM チャンネルを取得
+func (us *User) GetDM() *Channel {
+	if us == nil {
+		return nil
+	}
+	resp, _, err := Wsbot.API().UserApi.GetUserDMChannel(context.Background(), us.ID).Execute()
+	if err != nil {
+		log.Println(color.HiYellowString("[failed to get DM channel with @%s in GetDM()] %s", us.Name, err))
+		return nil
+	}
+	return &Channel{
+		Name:    us.Name,
+		Path:    "@" + us.Name,
+		ID:      resp.Id,
+		Partner: us,
+	}
+}
+
+// ユーザーに DM を送信し、投稿された全てのメッセージを返す
+// Channel.Send と同じく、MaxMessageLength を超える長さのメッセージは分割して順に投稿する
+func (us *User) SendDM(content string) []*Message {
+	if us == nil {
+		return []*Message{}
+	}
+	dm := us.GetDM()
+	if dm == nil {
+		return []*Message{}
+	}
+	return dm.Send(content)
+}
@@ -0,0 +1,98 @@
+package persona
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// 次のメッセージを待っている呼び出し
+type waiter struct {
+	filter Filter
+	found  chan *Message
+}
+
+var (
+	waiters   []*waiter
+	waitersMu sync.Mutex
+)
+
+// 条件に当てはまる次のメッセージが届くまで待つ。ctx がキャンセルされるかタイムアウトすると ctx.Err() を返す
+// filter が nil なら全てのメッセージが当てはまる
+// 待っている呼び出しに渡されたメッセージはコマンドやハンドラ、OnMessage には渡らない
+func Wait(ctx context.Context, filter Filter) (*Message, error) {
+	if filter == nil {
+		filter = All()
+	}
+	w := &waiter{filter: filter, found: make(chan *Message, 1)}
+
+	waitersMu.Lock()
+	waiters = append(waiters, w)
+	waitersMu.Unlock()
+
+	select {
+	case ms := <-w.found:
+		return ms, nil // deliver が waiters から取り除いているので、ここでは取り除かなくてよい
+	case <-ctx.Done():
+		// 先に waiters から取り除いてから found を確かめる。取り除く前に deliver が渡したメッセージは必ず found に入っている
+		waitersMu.Lock()
+		waiters = slices.DeleteFunc(waiters, func(other *waiter) bool { return other == w })
+		waitersMu.Unlock()
+
+		select {
+		case ms := <-w.found: // キャンセルと同時にメッセージが届いていた場合はそちらを優先する
+			return ms, nil
+		default:
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// 同じチャンネルで同じユーザーが次に投稿した、条件に当てはまるメッセージを待つ。filter は nil でもよい
+// ctx := context.WithTimeout(context.Background(), time.Minute) などとしてタイムアウトを設定できる
+func (ms *Message) WaitReply(ctx context.Context, filter Filter) (*Message, error) {
+	if (ms == nil) || (ms.Author == nil) {
+		return nil, fmt.Errorf("message is nil")
+	}
+	conditions := []Filter{sameChannel(ms.Channel.ID), sameAuthor(ms.Author.ID)}
+	if filter != nil {
+		conditions = append(conditions, filter)
+	}
+	return Wait(ctx, All(conditions...))
+}
+
+// チャンネルで引数の UUID をもつユーザーが次に投稿するメッセージを待つ
+func (ch *Channel) WaitFor(ctx context.Context, usID string) (*Message, error) {
+	if ch == nil {
+		return nil, fmt.Errorf("channel is nil")
+	}
+	return Wait(ctx, All(sameChannel(ch.ID), sameAuthor(usID)))
+}
+
+// 待っている呼び出しのうち最も古いものから順に条件を確かめ、当てはまったひとつにメッセージを渡す
+func deliver(ms *Message) bool {
+	waitersMu.Lock()
+	defer waitersMu.Unlock()
+
+	for i, w := range waiters {
+		if ok, _ := w.filter(ms); ok {
+			w.found <- ms // バッファがあるので詰まらない
+			waiters = slices.Delete(waiters, i, i+1)
+			return true
+		}
+	}
+	return false
+}
+
+func sameChannel(chID string) Filter {
+	return func(ms *Message) (bool, []string) {
+		return (ms.Channel != nil) && (ms.Channel.ID == chID), nil
+	}
+}
+
+func sameAuthor(usID string) Filter {
+	return func(ms *Message) (bool, []string) {
+		return (ms.Author != nil) && (ms.Author.ID == usID), nil
+	}
+}